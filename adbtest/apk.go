@@ -0,0 +1,110 @@
+package adbtest
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"unicode/utf16"
+)
+
+// ApkManifest
+// @Description: BuildApk 生成的 apk 中 AndroidManifest.xml 的内容
+type ApkManifest struct {
+	Package     string
+	VersionCode int64
+	VersionName string
+	Split       string // 非空时为 split apk
+}
+
+// BuildApk
+//
+//	@Description: 生成只包含二进制 AndroidManifest.xml 的 apk, 用于测试安装流程, 不能在真机上安装
+//	@param m
+//	@return []byte
+func BuildApk(m ApkManifest) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("AndroidManifest.xml")
+	_, _ = w.Write(buildManifest(m))
+	w, _ = zw.Create("classes.dex")
+	_, _ = w.Write([]byte("dex\n035\x00"))
+	_ = zw.Close()
+	return buf.Bytes()
+}
+
+// buildManifest 按 aapt 的格式生成二进制 xml, 只有 manifest 一个元素
+func buildManifest(m ApkManifest) []byte {
+	const none = 0xffffffff
+	pool := []string{"manifest", "package", "versionCode", "versionName", "split",
+		"http://schemas.android.com/apk/res/android", m.Package, m.VersionName, m.Split}
+	const (
+		idxManifest = iota
+		idxPackage
+		idxVersionCode
+		idxVersionName
+		idxSplit
+		idxNamespace
+		idxPackageValue
+		idxVersionNameValue
+		idxSplitValue
+	)
+	type attr struct {
+		ns, name, raw uint32
+		dataType      byte
+		data          uint32
+	}
+	attrs := []attr{
+		{none, idxPackage, idxPackageValue, 0x03, idxPackageValue},
+		{idxNamespace, idxVersionCode, none, 0x10, uint32(m.VersionCode)},
+		{idxNamespace, idxVersionName, idxVersionNameValue, 0x03, idxVersionNameValue},
+	}
+	if m.Split != "" {
+		attrs = append(attrs, attr{none, idxSplit, idxSplitValue, 0x03, idxSplitValue})
+	}
+
+	le := binary.LittleEndian
+	var body bytes.Buffer
+	put := func(values ...interface{}) {
+		for _, v := range values {
+			_ = binary.Write(&body, le, v)
+		}
+	}
+
+	// 字符串池, UTF-16 编码
+	var data bytes.Buffer
+	offsets := make([]uint32, len(pool))
+	for i, s := range pool {
+		offsets[i] = uint32(data.Len())
+		chars := utf16.Encode([]rune(s))
+		_ = binary.Write(&data, le, uint16(len(chars)))
+		_ = binary.Write(&data, le, chars)
+		_ = binary.Write(&data, le, uint16(0))
+	}
+	for data.Len()%4 != 0 {
+		data.WriteByte(0)
+	}
+	headerSize := 28
+	stringsStart := headerSize + 4*len(pool)
+	put(uint16(0x0001), uint16(headerSize), uint32(stringsStart+data.Len()),
+		uint32(len(pool)), uint32(0), uint32(0), uint32(stringsStart), uint32(0))
+	put(offsets)
+	body.Write(data.Bytes())
+
+	// 资源 id, 与字符串池的前几项对应
+	resIDs := []uint32{0, 0, 0x0101021b, 0x0101021c}
+	put(uint16(0x0180), uint16(8), uint32(8+4*len(resIDs)), resIDs)
+
+	// manifest 开始标签
+	put(uint16(0x0102), uint16(16), uint32(16+20+20*len(attrs)), uint32(1), uint32(none))
+	put(uint32(none), uint32(idxManifest), uint16(20), uint16(20), uint16(len(attrs)), uint16(0), uint16(0), uint16(0))
+	for _, a := range attrs {
+		put(a.ns, a.name, a.raw, uint16(8), byte(0), a.dataType, a.data)
+	}
+
+	var out bytes.Buffer
+	_ = binary.Write(&out, le, uint16(0x0003))
+	_ = binary.Write(&out, le, uint16(8))
+	_ = binary.Write(&out, le, uint32(8+body.Len()))
+	out.Write(body.Bytes())
+	return out.Bytes()
+}
@@ -0,0 +1,87 @@
+package adbtest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/youluo1230/adbutils"
+)
+
+// SND2/RCV2 的压缩 flags 及对应的 feature
+const (
+	syncFlagBrotli uint32 = 1
+	syncFlagLZ4    uint32 = 2
+	syncFlagZstd   uint32 = 4
+)
+
+var syncFlagFeatures = map[uint32]string{
+	syncFlagBrotli: adbutils.FeatureSendRecvV2Brotli,
+	syncFlagLZ4:    adbutils.FeatureSendRecvV2LZ4,
+	syncFlagZstd:   adbutils.FeatureSendRecvV2Zstd,
+}
+
+// checkSyncFlags 与 adbd 一致, 一次只能使用一种压缩, 且设备需要声明对应的 feature
+func (d *Device) checkSyncFlags(flags uint32) error {
+	if flags == 0 {
+		return nil
+	}
+	feature, ok := syncFlagFeatures[flags]
+	if !ok || !d.hasFeature(feature) {
+		return fmt.Errorf("unsupported sync flags %#x", flags)
+	}
+	return nil
+}
+
+// compress 按 flags 压缩整个文件内容, flags 为 0 时原样返回
+func compress(flags uint32, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch flags {
+	case 0:
+		return data, nil
+	case syncFlagBrotli:
+		w = brotli.NewWriter(&buf)
+	case syncFlagLZ4:
+		w = lz4.NewWriter(&buf)
+	case syncFlagZstd:
+		enc, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, err
+		}
+		w = enc
+	default:
+		return nil, fmt.Errorf("unsupported sync flags %#x", flags)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress 按 flags 解压收到的所有 DATA 内容, flags 为 0 时原样返回
+func decompress(flags uint32, data []byte) ([]byte, error) {
+	switch flags {
+	case 0:
+		return data, nil
+	case syncFlagBrotli:
+		return ioutil.ReadAll(brotli.NewReader(bytes.NewReader(data)))
+	case syncFlagLZ4:
+		return ioutil.ReadAll(lz4.NewReader(bytes.NewReader(data)))
+	case syncFlagZstd:
+		dec, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer dec.Close()
+		return dec.DecodeAll(data, nil)
+	}
+	return nil, fmt.Errorf("unsupported sync flags %#x", flags)
+}
@@ -0,0 +1,957 @@
+package adbtest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/youluo1230/adbutils"
+)
+
+// syncMaxChunk 与 adbd 一致, 单个 DATA 包最大 64K
+const syncMaxChunk = 64 * 1024
+
+// ShellRequest
+// @Description: 一次 shell 调用的输入, 处理函数可以向 Stdout/Stderr 持续输出,
+// 返回的 ShellResponse 中的内容会在处理函数结束后再写出
+type ShellRequest struct {
+	Command string
+	// shell v2 的选项, 如 [v2 TERM=xterm-256color pty], 旧版 shell: 服务为空
+	Options []string
+	Stdin   io.Reader
+	Stdout  io.Writer
+	Stderr  io.Writer
+	// shell v2 窗口大小变化, 格式同 adb 为 "<rows>x<cols>,<xpixels>x<ypixels>"
+	WindowSize <-chan string
+}
+
+// ShellResponse
+// @Description: 一次 shell 调用的输出, 旧版 shell: 服务会合并 Stdout 和 Stderr
+type ShellResponse struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+type ShellHandler func(req ShellRequest) ShellResponse
+
+// ServiceHandler 处理 shell/sync 之外的设备服务(如 tcp:、localabstract:), 调用前已回复 OKAY
+type ServiceHandler func(service string, conn net.Conn)
+
+type prefixShellHandler struct {
+	prefix  string
+	handler ShellHandler
+}
+
+type prefixServiceHandler struct {
+	prefix  string
+	handler ServiceHandler
+}
+
+// Device
+// @Description: 模拟的 android 设备, 提供内存文件系统和可编程的 shell 响应
+type Device struct {
+	Serial      string
+	Product     string
+	Model       string
+	DeviceName  string
+	DevPath     string
+	TransportID int
+	FS          *FileSystem
+
+	mu            sync.Mutex
+	state         string
+	features      []string
+	props         map[string]string
+	shells        map[string]ShellHandler
+	shellPrefixes []prefixShellHandler
+	services      []prefixServiceHandler
+	reverses      []adbutils.ReverseItem
+	dataPackets   int
+	packages      map[string]*Package
+	sessions      map[int]*installSession
+	lastSession   int
+	notify        func()
+}
+
+func newDevice(serial string) *Device {
+	return &Device{
+		Serial:     serial,
+		Product:    "sdk_phone_x86_64",
+		Model:      "Android_SDK_built_for_x86_64",
+		DeviceName: "generic_x86_64",
+		DevPath:    "usb:1-1",
+		FS:         newFileSystem(),
+		state:      "device",
+		features:   []string{"cmd", "shell_v2", "stat_v2", "ls_v2", "sendrecv_v2"},
+		props: map[string]string{
+			"ro.serialno":              serial,
+			"ro.build.version.sdk":     "30",
+			"ro.product.cpu.abi":       "x86_64",
+			"ro.product.model":         "Android SDK built for x86_64",
+			"sys.boot_completed":       "1",
+			"ro.build.version.release": "11",
+		},
+		shells:   map[string]ShellHandler{},
+		packages: map[string]*Package{},
+		sessions: map[int]*installSession{},
+	}
+}
+
+// SetState
+//
+//	@Description: 设置设备状态, 如 device、offline、unauthorized、recovery
+//	@receiver d
+//	@param state
+func (d *Device) SetState(state string) {
+	d.mu.Lock()
+	d.state = state
+	notify := d.notify
+	d.mu.Unlock()
+	if notify != nil {
+		notify()
+	}
+}
+
+func (d *Device) getState() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.state
+}
+
+func (d *Device) SetFeatures(features ...string) {
+	d.mu.Lock()
+	d.features = append([]string(nil), features...)
+	d.mu.Unlock()
+}
+
+func (d *Device) getFeatures() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.features...)
+}
+
+func (d *Device) SetProp(name, value string) {
+	d.mu.Lock()
+	d.props[name] = value
+	d.mu.Unlock()
+}
+
+// OnShell 为完全匹配的命令注册处理函数
+func (d *Device) OnShell(command string, handler ShellHandler) {
+	d.mu.Lock()
+	d.shells[command] = handler
+	d.mu.Unlock()
+}
+
+// OnShellPrefix 为指定前缀的命令注册处理函数, 完全匹配优先, 前缀按注册顺序匹配
+func (d *Device) OnShellPrefix(prefix string, handler ShellHandler) {
+	d.mu.Lock()
+	d.shellPrefixes = append(d.shellPrefixes, prefixShellHandler{prefix: prefix, handler: handler})
+	d.mu.Unlock()
+}
+
+// SetShellOutput 设置命令的固定输出, 退出码为 0
+func (d *Device) SetShellOutput(command, stdout string) {
+	d.OnShell(command, func(ShellRequest) ShellResponse {
+		return ShellResponse{Stdout: stdout}
+	})
+}
+
+// HandleService 为指定前缀的设备服务注册处理函数
+func (d *Device) HandleService(prefix string, handler ServiceHandler) {
+	d.mu.Lock()
+	d.services = append(d.services, prefixServiceHandler{prefix: prefix, handler: handler})
+	d.mu.Unlock()
+}
+
+func (d *Device) hasFeature(name string) bool {
+	for _, f := range d.getFeatures() {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Device) checkOnline() error {
+	switch d.getState() {
+	case "offline":
+		return errors.New("device offline")
+	case "unauthorized":
+		return errors.New(unauthorizedMessage)
+	case "authorizing":
+		return errors.New("device still authorizing")
+	}
+	return nil
+}
+
+// RunShell
+//
+//	@Description: 按已注册的处理函数执行命令, 未注册时支持 ; 分隔的多条命令以及 echo、getprop 等内置命令
+//	@receiver d
+//	@param req
+//	@return ShellResponse
+func (d *Device) RunShell(req ShellRequest) ShellResponse {
+	var stdout, stderr bytes.Buffer
+	req.Stdout, req.Stderr = &stdout, &stderr
+	code := d.runShell(req)
+	return ShellResponse{Stdout: stdout.String(), Stderr: stderr.String(), ExitCode: code}
+}
+
+// runShell 执行命令, 每条命令结束时将结果写入 req.Stdout/req.Stderr, 返回最后一条命令的退出码;
+// 命令按换行和 ; 拆分, 后续命令中的 $? 会被替换为上一条命令的退出码, # 之后的注释被忽略,
+// 以 & 结尾的后台命令同步执行且退出码为 0, 与 sh 一样 & 或空命令后紧跟 ; 时报语法错误;
+// 管道 a | b 中前一条命令的 stdout 作为后一条的 stdin
+func (d *Device) runShell(req ShellRequest) int {
+	d.mu.Lock()
+	handler, ok := d.shells[req.Command]
+	if !ok {
+		for _, p := range d.shellPrefixes {
+			if strings.HasPrefix(req.Command, p.prefix) {
+				handler, ok = p.handler, true
+				break
+			}
+		}
+	}
+	d.mu.Unlock()
+	if !ok && strings.ContainsAny(req.Command, ";\n") {
+		code := 0
+		for _, line := range strings.Split(req.Command, "\n") {
+			if i := strings.Index(line, "#"); i == 0 || (i > 0 && (line[i-1] == ' ' || line[i-1] == '\t')) {
+				line = line[:i]
+			}
+			commands := strings.Split(line, ";")
+			for i, command := range commands {
+				command = strings.TrimSpace(strings.Replace(command, "$?", strconv.Itoa(code), -1))
+				if i < len(commands)-1 && (command == "" || strings.HasSuffix(command, "&")) {
+					_, _ = io.WriteString(req.Stderr, "/system/bin/sh: syntax error: unexpected ';'\n")
+					return 2
+				}
+				background := strings.HasSuffix(command, "&")
+				if command = strings.TrimSpace(strings.TrimSuffix(command, "&")); command != "" {
+					sub := req
+					sub.Command = command
+					if code = d.runShell(sub); background {
+						code = 0
+					}
+				}
+			}
+		}
+		return code
+	}
+	if !ok && strings.Contains(req.Command, " | ") {
+		stages := strings.Split(req.Command, " | ")
+		code := 0
+		for i, command := range stages {
+			sub := req
+			sub.Command = strings.TrimSpace(command)
+			if i < len(stages)-1 {
+				var out bytes.Buffer
+				sub.Stdout = &out
+				code = d.runShell(sub)
+				req.Stdin = &out
+			} else {
+				code = d.runShell(sub)
+			}
+		}
+		return code
+	}
+	if !ok {
+		handler = d.builtinShell
+	}
+	res := handler(req)
+	_, _ = io.WriteString(req.Stdout, res.Stdout)
+	_, _ = io.WriteString(req.Stderr, res.Stderr)
+	return res.ExitCode
+}
+
+func (d *Device) builtinShell(req ShellRequest) ShellResponse {
+	args := splitShellWords(req.Command)
+	if len(args) == 0 {
+		return ShellResponse{}
+	}
+	switch args[0] {
+	case "echo":
+		return ShellResponse{Stdout: strings.Join(args[1:], " ") + "\n"}
+	case "true":
+		return ShellResponse{}
+	case "false":
+		return ShellResponse{ExitCode: 1}
+	case "getprop":
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if len(args) > 1 {
+			return ShellResponse{Stdout: d.props[args[1]] + "\n"}
+		}
+		var names []string
+		for name := range d.props {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var b strings.Builder
+		for _, name := range names {
+			fmt.Fprintf(&b, "[%s]: [%s]\n", name, d.props[name])
+		}
+		return ShellResponse{Stdout: b.String()}
+	case "mkdir":
+		for _, name := range args[1:] {
+			if name == "-p" {
+				continue
+			}
+			if f := d.FS.Stat(name); f != nil && !f.IsDir() {
+				return ShellResponse{Stderr: fmt.Sprintf("mkdir: '%s': File exists\n", name), ExitCode: 1}
+			}
+			d.FS.MkdirAll(name, 0775)
+		}
+		return ShellResponse{}
+	case "chmod":
+		// 只支持八进制模式
+		if len(args) < 3 {
+			return ShellResponse{Stderr: "chmod: Need 2 arguments\n", ExitCode: 1}
+		}
+		mode, err := strconv.ParseUint(args[1], 8, 32)
+		if err != nil {
+			return ShellResponse{Stderr: fmt.Sprintf("chmod: bad mode '%s'\n", args[1]), ExitCode: 1}
+		}
+		return d.updateFiles("chmod", args[2:], func(f *File) {
+			f.Mode = f.Mode&syscall.S_IFMT | uint32(mode)&07777
+		})
+	case "touch":
+		// 只支持 touch -m -d @<unix 时间> file
+		if len(args) < 5 || args[1] != "-m" || args[2] != "-d" || !strings.HasPrefix(args[3], "@") {
+			return ShellResponse{Stderr: "touch: unsupported arguments\n", ExitCode: 1}
+		}
+		sec, err := strconv.ParseInt(args[3][1:], 10, 64)
+		if err != nil {
+			return ShellResponse{Stderr: fmt.Sprintf("touch: bad date '%s'\n", args[3]), ExitCode: 1}
+		}
+		return d.updateFiles("touch", args[4:], func(f *File) {
+			f.Mtime = time.Unix(sec, 0)
+		})
+	case "readlink":
+		if len(args) > 1 {
+			if f := d.FS.Stat(args[len(args)-1]); f != nil && f.Mode&syscall.S_IFMT == syscall.S_IFLNK {
+				return ShellResponse{Stdout: string(f.Data) + "\n"}
+			}
+		}
+		return ShellResponse{ExitCode: 1}
+	case "rm":
+		for _, name := range args[1:] {
+			if !strings.HasPrefix(name, "-") {
+				d.FS.Remove(name)
+			}
+		}
+		return ShellResponse{}
+	case "pm":
+		return d.pm(args[1:])
+	case "cmd":
+		if len(args) > 1 && args[1] == "package" && d.hasFeature("cmd") {
+			return d.cmdPackage(req, args[2:])
+		}
+		return ShellResponse{Stderr: "cmd: Can't find service: " + strings.Join(args[1:2], "") + "\n", ExitCode: 20}
+	case "monkey":
+		return ShellResponse{Stdout: "Events injected: 1\n"}
+	case "sha256sum", "md5sum":
+		var b strings.Builder
+		names := args[1:]
+		if len(names) == 0 {
+			names = []string{"-"}
+		}
+		for _, name := range names {
+			data, ok := d.FS.ReadFile(name)
+			if name == "-" && req.Stdin != nil {
+				data, _ = io.ReadAll(req.Stdin)
+				ok = true
+			}
+			if !ok {
+				return ShellResponse{Stdout: b.String(), Stderr: fmt.Sprintf("%s: %s: No such file or directory\n", args[0], name), ExitCode: 1}
+			}
+			if args[0] == "md5sum" {
+				fmt.Fprintf(&b, "%x  %s\n", md5.Sum(data), name)
+			} else {
+				fmt.Fprintf(&b, "%x  %s\n", sha256.Sum256(data), name)
+			}
+		}
+		return ShellResponse{Stdout: b.String()}
+	case "head":
+		// 只支持 head -c N file
+		if len(args) == 4 && args[1] == "-c" {
+			n, err := strconv.Atoi(args[2])
+			data, ok := d.FS.ReadFile(args[3])
+			if err != nil || !ok {
+				return ShellResponse{Stderr: fmt.Sprintf("head: %s: No such file or directory\n", args[3]), ExitCode: 1}
+			}
+			if n < len(data) {
+				data = data[:n]
+			}
+			return ShellResponse{Stdout: string(data)}
+		}
+		return ShellResponse{Stderr: "head: unsupported arguments\n", ExitCode: 1}
+	case "tail":
+		// 只支持 tail -c +N file, 从第 N 个字节开始输出
+		if len(args) == 4 && args[1] == "-c" && strings.HasPrefix(args[2], "+") {
+			offset, err := strconv.Atoi(args[2][1:])
+			data, ok := d.FS.ReadFile(args[3])
+			if err != nil || !ok {
+				return ShellResponse{Stderr: fmt.Sprintf("tail: %s: No such file or directory\n", args[3]), ExitCode: 1}
+			}
+			if offset < 1 {
+				offset = 1
+			}
+			if offset > len(data) {
+				return ShellResponse{}
+			}
+			return ShellResponse{Stdout: string(data[offset-1:])}
+		}
+		return ShellResponse{Stderr: "tail: unsupported arguments\n", ExitCode: 1}
+	}
+	return ShellResponse{
+		Stderr:   fmt.Sprintf("/system/bin/sh: %s: inaccessible or not found\n", args[0]),
+		ExitCode: 127,
+	}
+}
+
+// updateFiles 修改已存在的文件项, 不存在时与 toybox 一样报错
+func (d *Device) updateFiles(command string, names []string, update func(f *File)) ShellResponse {
+	for _, name := range names {
+		f := d.FS.Stat(name)
+		if f == nil {
+			return ShellResponse{Stderr: fmt.Sprintf("%s: %s: No such file or directory\n", command, name), ExitCode: 1}
+		}
+		update(f)
+		d.FS.Put(name, f)
+	}
+	return ShellResponse{}
+}
+
+// splitShellWords 按空白拆分命令, 支持单引号和双引号, 足够解析 adbutils 生成的命令
+func splitShellWords(command string) []string {
+	var words []string
+	var word strings.Builder
+	inWord, escaped := false, false
+	var quote rune
+	for _, r := range command {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inWord = true, true
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// serve 处理切换到该设备之后的服务请求
+func (d *Device) serve(conn net.Conn) {
+	req, err := readRequest(conn)
+	if err != nil {
+		return
+	}
+	switch {
+	case strings.HasPrefix(req, "shell:"):
+		writeOkay(conn)
+		// 旧版 shell 服务按执行顺序合并 stdout 和 stderr
+		d.runShell(ShellRequest{Command: strings.TrimPrefix(req, "shell:"), Stdin: conn, Stdout: conn, Stderr: conn})
+	case strings.HasPrefix(req, "exec:"):
+		// exec: 与旧版 shell: 一样合并输出, 但不分配 pty
+		writeOkay(conn)
+		d.runShell(ShellRequest{Command: strings.TrimPrefix(req, "exec:"), Stdin: conn, Stdout: conn, Stderr: conn})
+	case strings.HasPrefix(req, "abb_exec:") && d.hasFeature("abb_exec"):
+		// abb_exec 的参数以 \0 分隔, 第一个为 binder 服务名, 只支持 package
+		writeOkay(conn)
+		args := strings.Split(strings.TrimPrefix(req, "abb_exec:"), "\x00")
+		resp := ShellResponse{Stderr: "Can't find service: " + args[0] + "\n", ExitCode: 20}
+		if args[0] == "package" {
+			resp = d.cmdPackage(ShellRequest{Stdin: conn}, args[1:])
+		}
+		_, _ = io.WriteString(conn, resp.Stdout+resp.Stderr)
+	case strings.HasPrefix(req, "shell,"):
+		i := strings.Index(req, ":")
+		if i < 0 || !d.hasFeature("shell_v2") {
+			writeFail(conn, "closed")
+			return
+		}
+		writeOkay(conn)
+		d.serveShellV2(conn, strings.Split(req[:i], ",")[1:], req[i+1:])
+	case req == "sync:":
+		writeOkay(conn)
+		d.serveSync(conn)
+	case strings.HasPrefix(req, "reverse:"):
+		writeOkay(conn)
+		d.serveReverse(conn, strings.TrimPrefix(req, "reverse:"))
+	default:
+		handler := d.serviceHandler(req)
+		if handler == nil {
+			writeFail(conn, "closed")
+			return
+		}
+		writeOkay(conn)
+		handler(req, conn)
+	}
+}
+
+// serviceHandler 返回第一个前缀匹配的 ServiceHandler, 没有时返回 nil
+func (d *Device) serviceHandler(service string) ServiceHandler {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, s := range d.services {
+		if strings.HasPrefix(service, s.prefix) {
+			return s.handler
+		}
+	}
+	return nil
+}
+
+// region reverse
+
+// Reverses 返回设备上当前的 reverse 转发, 按创建顺序排列
+func (d *Device) Reverses() []adbutils.ReverseItem {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]adbutils.ReverseItem(nil), d.reverses...)
+}
+
+// serveReverse 与 adbd 一致, 服务打开后再回复一个 OKAY 作为执行结果, list-forward 直接回复列表
+func (d *Device) serveReverse(conn net.Conn, command string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case command == "list-forward":
+		var lines []string
+		for _, r := range d.reverses {
+			lines = append(lines, fmt.Sprintf("host %s %s\n", r.Remote, r.Local))
+		}
+		writeBlock(conn, strings.Join(lines, ""))
+	case command == "killforward-all":
+		d.reverses = nil
+		writeOkay(conn)
+	case strings.HasPrefix(command, "killforward:"):
+		remote := strings.TrimPrefix(command, "killforward:")
+		for i, r := range d.reverses {
+			if r.Remote == remote {
+				d.reverses = append(d.reverses[:i], d.reverses[i+1:]...)
+				writeOkay(conn)
+				return
+			}
+		}
+		writeFail(conn, fmt.Sprintf("listener '%s' not found", remote))
+	case strings.HasPrefix(command, "forward:"):
+		spec := strings.TrimPrefix(command, "forward:")
+		noRebind := strings.HasPrefix(spec, "norebind:")
+		spec = strings.TrimPrefix(spec, "norebind:")
+		parts := strings.Split(spec, ";")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			writeFail(conn, fmt.Sprintf("bad forward: %s", spec))
+			return
+		}
+		for i, r := range d.reverses {
+			if r.Remote == parts[0] {
+				if noRebind {
+					writeFail(conn, "cannot rebind existing socket")
+					return
+				}
+				d.reverses[i].Local = parts[1]
+				writeOkay(conn)
+				return
+			}
+		}
+		d.reverses = append(d.reverses, adbutils.ReverseItem{Remote: parts[0], Local: parts[1]})
+		writeOkay(conn)
+	default:
+		writeFail(conn, "unknown reverse service")
+	}
+}
+
+// end region reverse
+
+// region shell v2
+
+const (
+	shellStdin            byte = 0
+	shellStdout           byte = 1
+	shellStderr           byte = 2
+	shellExit             byte = 3
+	shellCloseStdin       byte = 4
+	shellWindowSizeChange byte = 5
+)
+
+// serveShellV2 处理 shell,v2,<options>:<command>, stdin 包转发给处理函数, 输出按 stdout/stderr 分包, 结束时发送退出码
+func (d *Device) serveShellV2(conn net.Conn, options []string, command string) {
+	stdin, stdinWriter := io.Pipe()
+	windowSize := make(chan string, 16)
+	go func() {
+		defer stdinWriter.Close()
+		for {
+			id, data, err := readShellPacket(conn)
+			if err != nil {
+				return
+			}
+			switch id {
+			case shellStdin:
+				if _, err := stdinWriter.Write(data); err != nil {
+					return
+				}
+			case shellCloseStdin:
+				_ = stdinWriter.Close()
+			case shellWindowSizeChange:
+				select {
+				case windowSize <- string(data):
+				default:
+				}
+			}
+		}
+	}()
+	w := &shellPacketWriter{conn: conn}
+	code := d.runShell(ShellRequest{
+		Command:    command,
+		Options:    options,
+		Stdin:      stdin,
+		Stdout:     w.stream(shellStdout),
+		Stderr:     w.stream(shellStderr),
+		WindowSize: windowSize,
+	})
+	_ = stdin.Close()
+	_ = w.write(shellExit, []byte{byte(code)})
+}
+
+// shellPacketWriter 保证 stdout 和 stderr 的包不会交叉写入
+type shellPacketWriter struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (w *shellPacketWriter) write(id byte, data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return writeShellPacket(w.conn, id, data)
+}
+
+func (w *shellPacketWriter) stream(id byte) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		if err := w.write(id, p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	})
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func writeShellPacket(w io.Writer, id byte, data []byte) error {
+	msg := make([]byte, 5, 5+len(data))
+	msg[0] = id
+	binary.LittleEndian.PutUint32(msg[1:], uint32(len(data)))
+	_, err := w.Write(append(msg, data...))
+	return err
+}
+
+func readShellPacket(r io.Reader) (byte, []byte, error) {
+	head := make([]byte, 5)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, nil, err
+	}
+	data := make([]byte, binary.LittleEndian.Uint32(head[1:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+	return head[0], data, nil
+}
+
+// end region shell v2
+
+// region sync
+
+func (d *Device) serveSync(conn net.Conn) {
+	for {
+		id, payload, err := readSyncPacket(conn)
+		if err != nil {
+			return
+		}
+		name := string(payload)
+		// v2 命令只在设备声明了对应 feature 时可用
+		switch {
+		case id == "STAT":
+			d.syncStat(conn, name)
+		case (id == adbutils.STA2 || id == adbutils.LST2) && d.hasFeature(adbutils.FeatureStatV2):
+			d.syncStatV2(conn, id, name)
+		case id == "LIST":
+			d.syncList(conn, name)
+		case id == adbutils.LIS2 && d.hasFeature(adbutils.FeatureLsV2):
+			d.syncListV2(conn, name)
+		case id == "SEND":
+			name, mode := name, uint32(syscall.S_IFREG|0644)
+			if i := strings.LastIndex(name, ","); i >= 0 {
+				if m, err := strconv.ParseUint(name[i+1:], 10, 32); err == nil {
+					mode = uint32(m)
+				}
+				name = name[:i]
+			}
+			if !d.syncSend(conn, name, mode, 0) {
+				return
+			}
+		case id == adbutils.SND2 && d.hasFeature(adbutils.FeatureSendRecvV2):
+			// SND2 + mode + flags
+			head := make([]byte, 12)
+			if _, err := io.ReadFull(conn, head); err != nil || string(head[:4]) != adbutils.SND2 {
+				return
+			}
+			if !d.syncSend(conn, name, binary.LittleEndian.Uint32(head[4:]), binary.LittleEndian.Uint32(head[8:])) {
+				return
+			}
+		case id == "RECV":
+			if !d.syncRecv(conn, name, 0) {
+				return
+			}
+		case id == adbutils.RCV2 && d.hasFeature(adbutils.FeatureSendRecvV2):
+			// RCV2 + flags
+			head := make([]byte, 8)
+			if _, err := io.ReadFull(conn, head); err != nil || string(head[:4]) != adbutils.RCV2 {
+				return
+			}
+			if !d.syncRecv(conn, name, binary.LittleEndian.Uint32(head[4:])) {
+				return
+			}
+		case id == "QUIT":
+			return
+		default:
+			writeSyncFail(conn, "unknown sync command "+id)
+			return
+		}
+	}
+}
+
+func (d *Device) syncStat(conn net.Conn, name string) {
+	var mode, size, mtime uint32
+	if f := d.FS.Stat(name); f != nil {
+		// v1 中大小和时间只有 32 位, 超过 4G 的文件会被截断
+		mode, size, mtime = f.Mode, uint32(f.size()), uint32(f.Mtime.Unix())
+	}
+	_, _ = conn.Write(syncPacket("STAT", mode, size, mtime))
+}
+
+// syncList 与 adbd 一致, 列表中包含 . 和 ..
+func (d *Device) syncList(conn net.Conn, name string) {
+	names, ok := d.FS.List(name)
+	if ok {
+		names = append([]string{".", ".."}, names...)
+	}
+	for _, n := range names {
+		f := d.FS.Stat(path.Join(name, n))
+		if f == nil {
+			continue
+		}
+		msg := syncPacket(adbutils.DENT, f.Mode, uint32(f.size()), uint32(f.Mtime.Unix()), uint32(len(n)))
+		_, _ = conn.Write(append(msg, n...))
+	}
+	_, _ = conn.Write(syncPacket(adbutils.DONE, 0, 0, 0, 0))
+}
+
+// SyncDataPackets 返回推送文件时收到的 DATA 包总数, 用于检查客户端的分包
+func (d *Device) SyncDataPackets() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dataPackets
+}
+
+// syncSend 接收 DATA 直到 DONE, flags 指定压缩时先解压再写入文件
+func (d *Device) syncSend(conn net.Conn, name string, mode, flags uint32) bool {
+	if err := d.checkSyncFlags(flags); err != nil {
+		writeSyncFail(conn, err.Error())
+		return false
+	}
+	var data []byte
+	for {
+		id, payload, err := readSyncPacket(conn)
+		if err != nil {
+			return false
+		}
+		switch id {
+		case adbutils.DATA:
+			// adbd 会拒绝超过 64K 的 DATA 包
+			if len(payload) > syncMaxChunk {
+				writeSyncFail(conn, "oversize data message")
+				return false
+			}
+			d.mu.Lock()
+			d.dataPackets++
+			d.mu.Unlock()
+			data = append(data, payload...)
+		case adbutils.DONE:
+			if f := d.FS.Stat(name); f != nil && f.IsDir() {
+				writeSyncFail(conn, "couldn't create file: Is a directory")
+				return false
+			}
+			if data, err = decompress(flags, data); err != nil {
+				writeSyncFail(conn, "decompress failed: "+err.Error())
+				return false
+			}
+			d.FS.Put(name, &File{Mode: mode, Data: data, Mtime: time.Unix(int64(binary.LittleEndian.Uint32(payload)), 0)})
+			_, _ = conn.Write(syncPacket(adbutils.OKAY, 0))
+			return true
+		default:
+			writeSyncFail(conn, "invalid data message")
+			return false
+		}
+	}
+}
+
+// syncStatV2 回复 STA2/LST2, STA2 跟随符号链接, 文件不存在时 error 为 ENOENT, 其余字段为 0
+func (d *Device) syncStatV2(conn net.Conn, id, name string) {
+	f := d.FS.Stat(name)
+	if id == adbutils.STA2 {
+		f = d.FS.follow(name)
+	}
+	_, _ = conn.Write(append([]byte(id), statV2(name, f)...))
+}
+
+// syncListV2 与 syncList 相同, 每一项为 DNT2 + stat 结构 + 名称, 结束的 DONE 后也是同样大小的结构
+func (d *Device) syncListV2(conn net.Conn, name string) {
+	names, ok := d.FS.List(name)
+	if ok {
+		names = append([]string{".", ".."}, names...)
+	}
+	for _, n := range names {
+		p := path.Join(name, n)
+		f := d.FS.Stat(p)
+		if f == nil {
+			continue
+		}
+		msg := append([]byte(adbutils.DNT2), statV2(p, f)...)
+		msg = append(msg, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(msg[len(msg)-4:], uint32(len(n)))
+		_, _ = conn.Write(append(msg, n...))
+	}
+	_, _ = conn.Write(append([]byte(adbutils.DONE), make([]byte, 72)...))
+}
+
+// statV2 生成 id 之后的 stat 结构: error, dev, ino, mode, nlink, uid, gid, size, atime, mtime, ctime
+func statV2(name string, f *File) []byte {
+	le := binary.LittleEndian
+	bs := make([]byte, 68)
+	if f == nil {
+		le.PutUint32(bs, uint32(syscall.ENOENT))
+		return bs
+	}
+	// ino 由路径生成, 保证同一个文件稳定
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(path.Clean(name)))
+	nlink := uint32(1)
+	if f.IsDir() {
+		nlink = 2
+	}
+	le.PutUint64(bs[4:], 0xfd00)
+	le.PutUint64(bs[12:], h.Sum64())
+	le.PutUint32(bs[20:], f.Mode)
+	le.PutUint32(bs[24:], nlink)
+	le.PutUint32(bs[28:], f.Uid)
+	le.PutUint32(bs[32:], f.Gid)
+	le.PutUint64(bs[36:], uint64(f.size()))
+	for _, off := range []int{44, 52, 60} {
+		le.PutUint64(bs[off:], uint64(f.Mtime.Unix()))
+	}
+	return bs
+}
+
+// syncRecv 发送文件内容, flags 指定压缩时整个文件压缩后再按 DATA 分块
+func (d *Device) syncRecv(conn net.Conn, name string, flags uint32) bool {
+	if err := d.checkSyncFlags(flags); err != nil {
+		writeSyncFail(conn, err.Error())
+		return false
+	}
+	f := d.FS.follow(name)
+	if f == nil || f.IsDir() {
+		writeSyncFail(conn, "open failed: No such file or directory")
+		return false
+	}
+	data, err := compress(flags, f.Data)
+	if err != nil {
+		writeSyncFail(conn, err.Error())
+		return false
+	}
+	for len(data) > 0 {
+		n := len(data)
+		if n > syncMaxChunk {
+			n = syncMaxChunk
+		}
+		if _, err := conn.Write(append(syncPacket(adbutils.DATA, uint32(n)), data[:n]...)); err != nil {
+			return false
+		}
+		data = data[n:]
+	}
+	_, _ = conn.Write(syncPacket(adbutils.DONE, 0))
+	return true
+}
+
+// readSyncPacket 读取 id + 4字节小端长度 + 内容; DONE 包的长度位为 mtime, 不带内容
+func readSyncPacket(r io.Reader) (string, []byte, error) {
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return "", nil, err
+	}
+	id := string(head[:4])
+	if id == adbutils.DONE {
+		return id, head[4:], nil
+	}
+	body := make([]byte, binary.LittleEndian.Uint32(head[4:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return "", nil, err
+	}
+	return id, body, nil
+}
+
+func syncPacket(id string, values ...uint32) []byte {
+	msg := make([]byte, 4+4*len(values))
+	copy(msg, id)
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(msg[4+4*i:], v)
+	}
+	return msg
+}
+
+func writeSyncFail(w io.Writer, msg string) {
+	_, _ = w.Write(append(syncPacket(adbutils.FAIL, uint32(len(msg))), msg...))
+}
+
+// end region sync
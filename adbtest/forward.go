@@ -0,0 +1,147 @@
+package adbtest
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/youluo1230/adbutils"
+)
+
+// forwardListener
+// @Description: 一条 host 端转发, 目前只支持 tcp: 本地地址, 接受的连接交给设备上注册的 ServiceHandler 处理
+type forwardListener struct {
+	item     adbutils.ForwardItem
+	listener net.Listener
+}
+
+// Forwards 返回 server 上当前的转发, 按创建顺序排列
+func (s *Server) Forwards() []adbutils.ForwardItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := make([]adbutils.ForwardItem, 0, len(s.forwards))
+	for _, f := range s.forwards {
+		items = append(items, f.item)
+	}
+	return items
+}
+
+// forward
+//
+//	@Description: 处理 forward:[norebind:]<local>;<remote>, local 为 tcp:0 时分配一个空闲端口
+//	@receiver s
+//	@param d
+//	@param spec
+//	@return int 新分配的端口, 未分配时为 0
+//	@return error
+func (s *Server) forward(d *Device, spec string) (int, error) {
+	noRebind := strings.HasPrefix(spec, "norebind:")
+	spec = strings.TrimPrefix(spec, "norebind:")
+	parts := strings.Split(spec, ";")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return 0, fmt.Errorf("bad forward: %s", spec)
+	}
+	local, remote := parts[0], parts[1]
+	if !strings.HasPrefix(local, "tcp:") {
+		return 0, fmt.Errorf("cannot bind listener: unsupported local '%s'", local)
+	}
+	port, err := strconv.Atoi(strings.TrimPrefix(local, "tcp:"))
+	if err != nil {
+		return 0, fmt.Errorf("bad forward: %s", spec)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.forwards {
+		if port != 0 && f.item.Local == local {
+			if noRebind {
+				return 0, fmt.Errorf("cannot rebind existing socket")
+			}
+			f.item = adbutils.ForwardItem{Serial: d.Serial, Local: local, Remote: remote}
+			return 0, nil
+		}
+	}
+	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return 0, fmt.Errorf("cannot bind listener: %v", err)
+	}
+	allocated := 0
+	if port == 0 {
+		allocated = l.Addr().(*net.TCPAddr).Port
+		local = fmt.Sprintf("tcp:%d", allocated)
+	}
+	f := &forwardListener{
+		item:     adbutils.ForwardItem{Serial: d.Serial, Local: local, Remote: remote},
+		listener: l,
+	}
+	s.forwards = append(s.forwards, f)
+	s.wg.Add(1)
+	go s.serveForward(f)
+	return allocated, nil
+}
+
+// killForward 删除本地地址为 local 的转发
+func (s *Server) killForward(local string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, f := range s.forwards {
+		if f.item.Local == local {
+			_ = f.listener.Close()
+			s.forwards = append(s.forwards[:i], s.forwards[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("listener '%s' not found", local)
+}
+
+// killForwardAll 与 adb server 一致, 删除所有设备的转发
+func (s *Server) killForwardAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.forwards {
+		_ = f.listener.Close()
+	}
+	s.forwards = nil
+}
+
+func (s *Server) formatForwards() string {
+	var lines []string
+	for _, f := range s.Forwards() {
+		lines = append(lines, fmt.Sprintf("%s %s %s\n", f.Serial, f.Local, f.Remote))
+	}
+	return strings.Join(lines, "")
+}
+
+// serveForward 接受转发端口上的连接, 每个连接按当时的转发目标交给设备处理
+func (s *Server) serveForward(f *forwardListener) {
+	defer s.wg.Done()
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		item := f.item
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			if d := s.Device(item.Serial); d != nil {
+				if handler := d.serviceHandler(item.Remote); handler != nil {
+					handler(item.Remote, conn)
+				}
+			}
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			_ = conn.Close()
+		}()
+	}
+}
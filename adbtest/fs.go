@@ -0,0 +1,166 @@
+package adbtest
+
+import (
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// File
+// @Description: 虚拟文件系统中的一项, Mode 为包含文件类型的 unix mode
+type File struct {
+	Mode  uint32
+	Data  []byte
+	Mtime time.Time
+	Uid   uint32
+	Gid   uint32
+	// 非零时代替 len(Data) 作为 stat 返回的大小, 用于模拟超过 4G 的大文件
+	Size int64
+}
+
+func (f *File) IsDir() bool {
+	return f.Mode&syscall.S_IFMT == syscall.S_IFDIR
+}
+
+func (f *File) size() int64 {
+	if f.Size != 0 {
+		return f.Size
+	}
+	return int64(len(f.Data))
+}
+
+// FileSystem
+// @Description: 虚拟设备的内存文件系统, 路径均为以 / 开头的绝对路径
+type FileSystem struct {
+	mu    sync.Mutex
+	files map[string]*File
+}
+
+func newFileSystem() *FileSystem {
+	fs := &FileSystem{files: map[string]*File{}}
+	fs.files["/"] = &File{Mode: syscall.S_IFDIR | 0755, Mtime: time.Now()}
+	for _, dir := range []string{"/sdcard", "/data/local/tmp"} {
+		fs.MkdirAll(dir, 0775)
+	}
+	return fs
+}
+
+// WriteFile
+//
+//	@Description: 写入文件, 父目录不存在时自动创建
+//	@receiver fs
+//	@param name
+//	@param data
+//	@param perm
+func (fs *FileSystem) WriteFile(name string, data []byte, perm os.FileMode) {
+	fs.Put(name, &File{Mode: syscall.S_IFREG | uint32(perm.Perm()), Data: data, Mtime: time.Now()})
+}
+
+// Put
+//
+//	@Description: 放入任意类型的文件项, 如符号链接(Data 为链接目标)
+//	@receiver fs
+//	@param name
+//	@param f
+func (fs *FileSystem) Put(name string, f *File) {
+	name = path.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.mkdirAll(path.Dir(name), 0775)
+	fs.files[name] = f
+}
+
+func (fs *FileSystem) ReadFile(name string) ([]byte, bool) {
+	f := fs.Stat(name)
+	if f == nil || f.IsDir() {
+		return nil, false
+	}
+	return f.Data, true
+}
+
+func (fs *FileSystem) MkdirAll(name string, perm os.FileMode) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.mkdirAll(path.Clean(name), perm)
+}
+
+func (fs *FileSystem) mkdirAll(name string, perm os.FileMode) {
+	if _, ok := fs.files[name]; ok {
+		return
+	}
+	fs.mkdirAll(path.Dir(name), perm)
+	fs.files[name] = &File{Mode: syscall.S_IFDIR | uint32(perm.Perm()), Mtime: time.Now()}
+}
+
+// Stat
+//
+//	@Description: 返回文件项的拷贝, 不存在时返回 nil
+//	@receiver fs
+//	@param name
+//	@return *File
+func (fs *FileSystem) Stat(name string) *File {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	f, ok := fs.files[path.Clean(name)]
+	if !ok {
+		return nil
+	}
+	cp := *f
+	return &cp
+}
+
+// follow 同 Stat, 但跟随符号链接, 链接失效或成环时返回 nil
+func (fs *FileSystem) follow(name string) *File {
+	for i := 0; i < 40; i++ {
+		f := fs.Stat(name)
+		if f == nil || f.Mode&syscall.S_IFMT != syscall.S_IFLNK {
+			return f
+		}
+		target := string(f.Data)
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(name), target)
+		}
+		name = target
+	}
+	return nil
+}
+
+// Remove 删除文件或目录(包括其子项)
+func (fs *FileSystem) Remove(name string) {
+	name = path.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for p := range fs.files {
+		if p == name || strings.HasPrefix(p, name+"/") {
+			delete(fs.files, p)
+		}
+	}
+}
+
+// List
+//
+//	@Description: 列出目录下的直接子项, 按名称排序
+//	@receiver fs
+//	@param name
+//	@return []string
+//	@return bool 目录是否存在
+func (fs *FileSystem) List(name string) ([]string, bool) {
+	name = path.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if f, ok := fs.files[name]; !ok || !f.IsDir() {
+		return nil, false
+	}
+	var names []string
+	for p := range fs.files {
+		if p != name && path.Dir(p) == name {
+			names = append(names, path.Base(p))
+		}
+	}
+	sort.Strings(names)
+	return names, true
+}
@@ -0,0 +1,343 @@
+package adbtest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/youluo1230/adbutils"
+)
+
+// Package
+// @Description: 模拟设备上已安装的应用
+type Package struct {
+	Name        string
+	VersionCode int64
+	VersionName string
+	Path        string   // base.apk 的路径
+	Splits      []string // 通过安装会话安装的 split apk 名称
+	System      bool
+	Disabled    bool
+	Installer   string
+	UID         int
+}
+
+// installSession 模拟 pm install-create 创建的安装会话
+type installSession struct {
+	flags map[string]string
+	apks  map[string][]byte
+}
+
+// Packages 返回已安装的应用, 按包名排序
+func (d *Device) Packages() []Package {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	packages := make([]Package, 0, len(d.packages))
+	for _, p := range d.packages {
+		packages = append(packages, *p)
+	}
+	sort.Slice(packages, func(i, j int) bool { return packages[i].Name < packages[j].Name })
+	return packages
+}
+
+// AddPackage 直接添加已安装的应用, Path 为空时使用 /data/app/<包名>/base.apk
+func (d *Device) AddPackage(p Package) {
+	if p.Path == "" {
+		p.Path = "/data/app/" + p.Name + "/base.apk"
+	}
+	d.mu.Lock()
+	d.packages[p.Name] = &p
+	d.mu.Unlock()
+}
+
+func (d *Device) getPackage(name string) *Package {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.packages[name]
+}
+
+// pm 模拟 pm 命令, 支持 install 和 uninstall
+func (d *Device) pm(args []string) ShellResponse {
+	if len(args) == 0 {
+		return ShellResponse{Stderr: "usage: pm [subcommand] [options]\n", ExitCode: 1}
+	}
+	switch args[0] {
+	case "install":
+		return d.pmInstall(args[1:])
+	case "list":
+		if len(args) > 1 && args[1] == "packages" {
+			return d.listPackages(args[2:])
+		}
+	case "install-create":
+		flags, _ := parseInstallArgs(args[1:])
+		d.mu.Lock()
+		d.lastSession++
+		id := d.lastSession
+		d.sessions[id] = &installSession{flags: flags, apks: map[string][]byte{}}
+		d.mu.Unlock()
+		return ShellResponse{Stdout: fmt.Sprintf("Success: created install session [%d]\n", id)}
+	case "install-write":
+		return d.installWrite(nil, args[1:])
+	case "install-commit":
+		return d.installCommit(args[1:])
+	case "install-abandon":
+		if len(args) < 2 || d.takeSession(args[1]) == nil {
+			return ShellResponse{Stderr: "Error: invalid session\n", ExitCode: 1}
+		}
+		return ShellResponse{Stdout: "Success\n"}
+	case "uninstall":
+		if len(args) < 2 || d.getPackage(args[len(args)-1]) == nil {
+			return ShellResponse{Stdout: "Failure [DELETE_FAILED_INTERNAL_ERROR]\n", ExitCode: 1}
+		}
+		d.mu.Lock()
+		delete(d.packages, args[len(args)-1])
+		d.mu.Unlock()
+		return ShellResponse{Stdout: "Success\n"}
+	}
+	return ShellResponse{Stderr: fmt.Sprintf("Unknown command: %s\n", args[0]), ExitCode: 1}
+}
+
+// pmInstall 安装设备上的 apk 文件
+func (d *Device) pmInstall(args []string) ShellResponse {
+	flags, apk := parseInstallArgs(args)
+	data, ok := d.FS.ReadFile(apk)
+	if !ok {
+		return ShellResponse{Stderr: fmt.Sprintf("Error: Unable to open file: %s\n", apk), ExitCode: 1}
+	}
+	return d.installApk(data, flags)
+}
+
+// cmdPackage 模拟 cmd package 和 abb_exec:package, install -S <size> 时从标准输入读取 apk
+func (d *Device) cmdPackage(req ShellRequest, args []string) ShellResponse {
+	if len(args) > 0 && args[0] == "install-write" {
+		return d.installWrite(req.Stdin, args[1:])
+	}
+	if len(args) == 0 || args[0] != "install" {
+		return d.pm(args)
+	}
+	flags, apk := parseInstallArgs(args[1:])
+	size, ok := flags["-S"]
+	if !ok {
+		return d.pmInstall(args[1:])
+	}
+	n, err := strconv.Atoi(size)
+	if err != nil {
+		return ShellResponse{Stderr: "Error: invalid size " + size + "\n", ExitCode: 1}
+	}
+	if apk != "" {
+		return ShellResponse{Stderr: "Error: APK content must be streamed\n", ExitCode: 1}
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(req.Stdin, data); err != nil {
+		return ShellResponse{Stdout: "Failure [INSTALL_FAILED_INVALID_APK: short read]\n", ExitCode: 1}
+	}
+	return d.installApk(data, flags)
+}
+
+// parseInstallArgs 拆分 install 的选项和 apk 路径, 带值的选项保存其值, 其他为空字符串
+func parseInstallArgs(args []string) (map[string]string, string) {
+	flags := map[string]string{}
+	var apk string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--abi" || arg == "--user" || arg == "-i" || arg == "-S":
+			if i+1 < len(args) {
+				flags[arg] = args[i+1]
+			}
+			i++
+		case strings.HasPrefix(arg, "-"):
+			flags[arg] = ""
+		default:
+			apk = arg
+		}
+	}
+	return flags, apk
+}
+
+// installApk 解析 apk 的包名和版本并记录为已安装, 未指定 -d 时拒绝降级
+func (d *Device) installApk(data []byte, flags map[string]string) ShellResponse {
+	info, err := adbutils.ParseApkReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return ShellResponse{Stdout: "Failure [INSTALL_PARSE_FAILED_NOT_APK: Failed to parse apk]\n", ExitCode: 1}
+	}
+	if resp, ok := d.checkDowngrade(info, flags); !ok {
+		return resp
+	}
+	d.AddPackage(Package{Name: info.Package, VersionCode: info.VersionCode, VersionName: info.VersionName})
+	return ShellResponse{Stdout: "Success\n"}
+}
+
+// listPackages 模拟 pm list packages, 支持 -s -3 -d -e -f -i -U --show-versioncode 和包名过滤
+func (d *Device) listPackages(args []string) ShellResponse {
+	flags := map[string]bool{}
+	var filter string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--user":
+			i++
+		case strings.HasPrefix(args[i], "-"):
+			flags[args[i]] = true
+		default:
+			filter = args[i]
+		}
+	}
+	var b strings.Builder
+	for _, p := range d.Packages() {
+		if flags["-s"] && !p.System || flags["-3"] && p.System || flags["-d"] && !p.Disabled || flags["-e"] && p.Disabled ||
+			!strings.Contains(p.Name, filter) {
+			continue
+		}
+		b.WriteString("package:")
+		if flags["-f"] {
+			b.WriteString(p.Path + "=")
+		}
+		b.WriteString(p.Name)
+		if flags["--show-versioncode"] {
+			fmt.Fprintf(&b, " versionCode:%d", p.VersionCode)
+		}
+		if flags["-i"] {
+			installer := p.Installer
+			if installer == "" {
+				installer = "null"
+			}
+			b.WriteString("  installer=" + installer)
+		}
+		if flags["-U"] {
+			fmt.Fprintf(&b, " uid:%d", p.UID)
+		}
+		b.WriteString("\n")
+	}
+	return ShellResponse{Stdout: b.String()}
+}
+
+// checkDowngrade 未指定 -d 时拒绝降级
+func (d *Device) checkDowngrade(info *adbutils.ApkInfo, flags map[string]string) (ShellResponse, bool) {
+	_, downgrade := flags["-d"]
+	if old := d.getPackage(info.Package); old != nil && old.VersionCode > info.VersionCode && !downgrade {
+		return ShellResponse{
+			Stdout: fmt.Sprintf("Failure [INSTALL_FAILED_VERSION_DOWNGRADE: Downgrade detected: Update version code %d is older than current %d]\n",
+				info.VersionCode, old.VersionCode),
+			ExitCode: 1,
+		}, false
+	}
+	return ShellResponse{}, true
+}
+
+// InstallSessions 返回未提交也未放弃的安装会话数量
+func (d *Device) InstallSessions() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.sessions)
+}
+
+func (d *Device) getSession(id string) *installSession {
+	n, _ := strconv.Atoi(id)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.sessions[n]
+}
+
+// takeSession 取出并删除会话
+func (d *Device) takeSession(id string) *installSession {
+	n, _ := strconv.Atoi(id)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := d.sessions[n]
+	delete(d.sessions, n)
+	return s
+}
+
+// installWrite 处理 install-write -S <size> <session> <name> <path>, path 为 - 时从 stdin 读取
+func (d *Device) installWrite(stdin io.Reader, args []string) ShellResponse {
+	flags, _ := parseInstallArgs(args)
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-S" {
+			i++
+			continue
+		}
+		rest = append(rest, args[i])
+	}
+	if len(rest) != 3 {
+		return ShellResponse{Stderr: "Error: usage: install-write [-S size] SESSION NAME PATH\n", ExitCode: 1}
+	}
+	session := d.getSession(rest[0])
+	if session == nil {
+		return ShellResponse{Stderr: "Error: invalid session " + rest[0] + "\n", ExitCode: 1}
+	}
+	var data []byte
+	if rest[2] == "-" {
+		n, err := strconv.Atoi(flags["-S"])
+		if stdin == nil || err != nil {
+			return ShellResponse{Stderr: "Error: -S is required when streaming\n", ExitCode: 1}
+		}
+		data = make([]byte, n)
+		if _, err := io.ReadFull(stdin, data); err != nil {
+			return ShellResponse{Stderr: "Error: short read\n", ExitCode: 1}
+		}
+	} else {
+		var ok bool
+		if data, ok = d.FS.ReadFile(rest[2]); !ok {
+			return ShellResponse{Stderr: fmt.Sprintf("Error: Unable to open file: %s\n", rest[2]), ExitCode: 1}
+		}
+	}
+	d.mu.Lock()
+	session.apks[rest[1]] = data
+	d.mu.Unlock()
+	return ShellResponse{Stdout: fmt.Sprintf("Success: streamed %d bytes\n", len(data))}
+}
+
+// installCommit 检查会话中有且只有一个 base apk, 且包名相同, 通过后记录为已安装
+func (d *Device) installCommit(args []string) ShellResponse {
+	if len(args) == 0 {
+		return ShellResponse{Stderr: "Error: invalid session\n", ExitCode: 1}
+	}
+	session := d.takeSession(args[0])
+	if session == nil {
+		return ShellResponse{Stderr: "Error: invalid session " + args[0] + "\n", ExitCode: 1}
+	}
+	failure := func(code, msg string) ShellResponse {
+		return ShellResponse{Stdout: fmt.Sprintf("Failure [%s: %s]\n", code, msg), ExitCode: 1}
+	}
+	var base *adbutils.ApkInfo
+	var splits []string
+	names := make([]string, 0, len(session.apks))
+	for name := range session.apks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	infos := make([]*adbutils.ApkInfo, 0, len(names))
+	for _, name := range names {
+		data := session.apks[name]
+		info, err := adbutils.ParseApkReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return failure("INSTALL_PARSE_FAILED_NOT_APK", "Failed to parse "+name)
+		}
+		infos = append(infos, info)
+		if info.Split == "" {
+			if base != nil {
+				return failure("INSTALL_FAILED_INVALID_APK", "Split null was defined multiple times")
+			}
+			base = info
+		} else {
+			splits = append(splits, info.Split)
+		}
+	}
+	if base == nil {
+		return failure("INSTALL_FAILED_INVALID_APK", "Missing existing base package")
+	}
+	for _, info := range infos {
+		if info.Package != base.Package {
+			return failure("INSTALL_FAILED_INVALID_APK", "Inconsistent package "+info.Package+" in "+info.Split)
+		}
+	}
+	if resp, ok := d.checkDowngrade(base, session.flags); !ok {
+		return resp
+	}
+	sort.Strings(splits)
+	d.AddPackage(Package{Name: base.Package, VersionCode: base.VersionCode, VersionName: base.VersionName, Splits: splits})
+	return ShellResponse{Stdout: "Success\n"}
+}
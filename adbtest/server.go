@@ -0,0 +1,552 @@
+// Package adbtest
+// @Description: 进程内的模拟 adb server, 实现 smart socket 协议, 用于在没有真实设备的情况下测试 adbutils
+package adbtest
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/youluo1230/adbutils"
+)
+
+const unauthorizedMessage = "device unauthorized.\n" +
+	"This adb server's $ADB_VENDOR_KEYS is not set\n" +
+	"Try 'adb kill-server' if that seems wrong.\n" +
+	"Otherwise check for a confirmation dialog on your device."
+
+// Server
+// @Description: 模拟的 adb server, 监听本地随机端口
+type Server struct {
+	Version int
+
+	listener net.Listener
+	mu       sync.Mutex
+	devices  []*Device
+	nextID   int
+	conns    map[net.Conn]struct{}
+	forwards []*forwardListener
+	wg       sync.WaitGroup
+	closed   bool
+	// 设备列表或状态变化时关闭并替换, 用于唤醒 track-devices
+	changed chan struct{}
+	done    chan struct{}
+}
+
+// NewServer
+//
+//	@Description: 启动一个模拟 adb server, 使用完毕后调用 Close
+//	@return *Server
+//	@return error
+func NewServer() (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Version:  41,
+		listener: l,
+		nextID:   1,
+		conns:    map[net.Conn]struct{}{},
+		changed:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+func (s *Server) Host() string {
+	return s.listener.Addr().(*net.TCPAddr).IP.String()
+}
+
+func (s *Server) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Client
+//
+//	@Description: 返回连接到该模拟服务端的客户端
+//	@receiver s
+//	@return *adbutils.AdbClient
+func (s *Server) Client() *adbutils.AdbClient {
+	return &adbutils.AdbClient{Host: s.Host(), Port: s.Port()}
+}
+
+// Close
+//
+//	@Description: 停止监听并断开所有连接
+//	@receiver s
+//	@return error
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	err := s.listener.Close()
+	for _, f := range s.forwards {
+		_ = f.listener.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// AddDevice
+//
+//	@Description: 添加一个在线的虚拟设备, 分配递增的 transport id
+//	@receiver s
+//	@param serial
+//	@return *Device
+func (s *Server) AddDevice(serial string) *Device {
+	d := newDevice(serial)
+	d.notify = s.notify
+	s.mu.Lock()
+	d.TransportID = s.nextID
+	s.nextID++
+	s.devices = append(s.devices, d)
+	s.mu.Unlock()
+	s.notify()
+	return d
+}
+
+func (s *Server) RemoveDevice(serial string) {
+	s.mu.Lock()
+	for i, d := range s.devices {
+		if d.Serial == serial {
+			s.devices = append(s.devices[:i], s.devices[i+1:]...)
+			break
+		}
+	}
+	s.mu.Unlock()
+	s.notify()
+}
+
+// CloseConnections
+//
+//	@Description: 断开所有已建立的连接但继续监听, 模拟 adb server 重启
+//	@receiver s
+func (s *Server) CloseConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+}
+
+func (s *Server) notify() {
+	s.mu.Lock()
+	close(s.changed)
+	s.changed = make(chan struct{})
+	s.mu.Unlock()
+}
+
+func (s *Server) Device(serial string) *Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range s.devices {
+		if d.Serial == serial {
+			return d
+		}
+	}
+	return nil
+}
+
+func (s *Server) Devices() []*Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Device(nil), s.devices...)
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			_ = conn.Close()
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	for {
+		req, err := readRequest(conn)
+		if err != nil {
+			return
+		}
+		switch {
+		case req == "host:version":
+			writeOkayBlock(conn, fmt.Sprintf("%04x", s.Version))
+		case req == "host:kill":
+			writeOkay(conn)
+			return
+		case req == "host:devices", req == "host:devices-l":
+			writeOkayBlock(conn, s.formatDevices(req == "host:devices-l"))
+		case req == "host:track-devices", req == "host:track-devices-l":
+			writeOkay(conn)
+			s.trackDevices(conn, req == "host:track-devices-l")
+			return
+		case strings.Contains(req, ":wait-for-"):
+			s.waitFor(conn, req)
+			return
+		case strings.HasPrefix(req, "host:connect:"):
+			addr := strings.TrimPrefix(req, "host:connect:")
+			if s.Device(addr) != nil {
+				writeOkayBlock(conn, "already connected to "+addr)
+			} else {
+				s.AddDevice(addr)
+				writeOkayBlock(conn, "connected to "+addr)
+			}
+		case strings.HasPrefix(req, "host:disconnect:"):
+			addr := strings.TrimPrefix(req, "host:disconnect:")
+			if s.Device(addr) == nil {
+				writeFail(conn, fmt.Sprintf("no such device '%s'", addr))
+				return
+			}
+			s.RemoveDevice(addr)
+			writeOkayBlock(conn, "disconnected "+addr)
+		case strings.HasPrefix(req, "host:transport"):
+			d, err := s.transport(req)
+			if err != nil {
+				writeFail(conn, err.Error())
+				return
+			}
+			writeOkay(conn)
+			d.serve(conn)
+			return
+		case req == "host:get-serialno", req == "host:get-state":
+			// 不指定设备时只有一个设备才能执行
+			d, err := s.transport("host:transport-any")
+			if err != nil {
+				writeFail(conn, err.Error())
+				return
+			}
+			if !s.hostService(conn, d, strings.TrimPrefix(req, "host:")) {
+				return
+			}
+		case strings.HasPrefix(req, "host-serial:"), strings.HasPrefix(req, "host-transport-id:"):
+			d, service, err := s.hostTransport(req)
+			if err != nil {
+				writeFail(conn, err.Error())
+				return
+			}
+			if !s.hostService(conn, d, service) {
+				return
+			}
+		default:
+			writeFail(conn, "unknown host service")
+			return
+		}
+	}
+}
+
+// transport
+//
+//	@Description: 解析 host:transport:<serial> / host:transport-id:<id> / host:transport-any
+//	@receiver s
+//	@param req
+//	@return *Device
+//	@return error
+func (s *Server) transport(req string) (*Device, error) {
+	var d *Device
+	switch {
+	case strings.HasPrefix(req, "host:transport:"):
+		serial := strings.TrimPrefix(req, "host:transport:")
+		if d = s.Device(serial); d == nil {
+			return nil, fmt.Errorf("device '%s' not found", serial)
+		}
+	case strings.HasPrefix(req, "host:transport-id:"):
+		id, _ := strconv.Atoi(strings.TrimPrefix(req, "host:transport-id:"))
+		if d = s.deviceByID(id); d == nil {
+			return nil, fmt.Errorf("no device with transport id '%d'", id)
+		}
+	case req == "host:transport-any":
+		devices := s.Devices()
+		if len(devices) == 0 {
+			return nil, fmt.Errorf("no devices/emulators found")
+		}
+		if len(devices) > 1 {
+			return nil, fmt.Errorf("more than one device/emulator")
+		}
+		d = devices[0]
+	default:
+		return nil, fmt.Errorf("unknown host service")
+	}
+	return d, d.checkOnline()
+}
+
+// hostTransport
+//
+//	@Description: 解析 host-serial:<serial>:<service>, serial 中可能包含冒号(如 127.0.0.1:5555)
+//	@receiver s
+//	@param req
+//	@return *Device
+//	@return string
+//	@return error
+func (s *Server) hostTransport(req string) (*Device, string, error) {
+	if strings.HasPrefix(req, "host-transport-id:") {
+		rest := strings.TrimPrefix(req, "host-transport-id:")
+		i := strings.Index(rest, ":")
+		if i < 0 {
+			return nil, "", fmt.Errorf("unknown host service")
+		}
+		id, _ := strconv.Atoi(rest[:i])
+		d := s.deviceByID(id)
+		if d == nil {
+			return nil, "", fmt.Errorf("no device with transport id '%d'", id)
+		}
+		return d, rest[i+1:], nil
+	}
+	rest := strings.TrimPrefix(req, "host-serial:")
+	for _, d := range s.Devices() {
+		if strings.HasPrefix(rest, d.Serial+":") {
+			return d, strings.TrimPrefix(rest, d.Serial+":"), nil
+		}
+	}
+	serial := rest
+	if i := strings.LastIndex(rest, ":"); i >= 0 {
+		serial = rest[:i]
+	}
+	return nil, "", fmt.Errorf("device '%s' not found", serial)
+}
+
+// hostService
+//
+//	@Description: 处理指定设备的 host 服务, 返回 false 表示需要关闭连接
+//	@receiver s
+//	@param conn
+//	@param d
+//	@param service
+//	@return bool
+func (s *Server) hostService(conn net.Conn, d *Device, service string) bool {
+	switch {
+	case service == "get-state":
+		writeOkayBlock(conn, d.getState())
+	case service == "get-serialno":
+		writeOkayBlock(conn, d.Serial)
+	case service == "get-devpath":
+		writeOkayBlock(conn, d.DevPath)
+	case service == "features":
+		writeOkayBlock(conn, strings.Join(d.getFeatures(), ","))
+	case service == "list-forward":
+		writeOkayBlock(conn, s.formatForwards())
+	case strings.HasPrefix(service, "forward:"):
+		port, err := s.forward(d, strings.TrimPrefix(service, "forward:"))
+		if err != nil {
+			writeFail(conn, err.Error())
+			return false
+		}
+		// host 端第一个 OKAY 表示连接成功, 第二个是执行结果, tcp:0 时再返回分配的端口
+		writeOkay(conn)
+		writeOkay(conn)
+		if port != 0 {
+			writeBlock(conn, strconv.Itoa(port))
+		}
+	case strings.HasPrefix(service, "killforward:"):
+		if err := s.killForward(strings.TrimPrefix(service, "killforward:")); err != nil {
+			writeFail(conn, err.Error())
+			return false
+		}
+		writeOkay(conn)
+		writeOkay(conn)
+	case service == "killforward-all":
+		s.killForwardAll()
+		writeOkay(conn)
+		writeOkay(conn)
+	default:
+		writeFail(conn, "unknown host service")
+		return false
+	}
+	return true
+}
+
+// trackDevices 先发送当前设备列表, 之后每次变化发送一次完整列表, 直到客户端断开
+func (s *Server) trackDevices(conn net.Conn, long bool) {
+	gone := watchGone(conn)
+	last := ""
+	for first := true; ; first = false {
+		s.mu.Lock()
+		changed := s.changed
+		s.mu.Unlock()
+		if list := s.formatDevices(long); first || list != last {
+			if _, err := fmt.Fprintf(conn, "%04x%s", len(list), list); err != nil {
+				return
+			}
+			last = list
+		}
+		select {
+		case <-changed:
+		case <-gone:
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// waitFor
+//
+//	@Description: 处理 host[-serial:<serial>]:wait-for-<transport>-<state>, 先回复 OKAY, 状态满足后再回复一次 OKAY
+//	@receiver s
+//	@param conn
+//	@param req
+func (s *Server) waitFor(conn net.Conn, req string) {
+	i := strings.LastIndex(req, ":wait-for-")
+	serial := strings.TrimPrefix(req[:i], "host-serial:")
+	if serial == "host" {
+		serial = ""
+	}
+	parts := strings.SplitN(req[i+len(":wait-for-"):], "-", 2)
+	if len(parts) != 2 || !map[string]bool{"any": true, "usb": true, "local": true}[parts[0]] {
+		writeFail(conn, "bad wait-for request: "+req)
+		return
+	}
+	state := parts[1]
+	switch state {
+	case "device", "recovery", "rescue", "sideload", "bootloader", "disconnect":
+	default:
+		writeFail(conn, "invalid state: "+state)
+		return
+	}
+	writeOkay(conn)
+	gone := watchGone(conn)
+	for {
+		s.mu.Lock()
+		changed := s.changed
+		s.mu.Unlock()
+		if s.reached(serial, state) {
+			writeOkay(conn)
+			return
+		}
+		select {
+		case <-changed:
+		case <-gone:
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// reached 判断设备是否处于等待的状态, serial 为空时匹配任意设备
+func (s *Server) reached(serial, state string) bool {
+	present := false
+	for _, d := range s.Devices() {
+		if serial != "" && d.Serial != serial {
+			continue
+		}
+		current := d.getState()
+		if current != "offline" {
+			present = true
+		}
+		if current == state {
+			return true
+		}
+	}
+	return state == "disconnect" && !present
+}
+
+// watchGone 客户端断开连接时关闭返回的管道, 之后该连接上的输入都会被丢弃
+func watchGone(conn net.Conn) <-chan struct{} {
+	gone := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(ioutil.Discard, conn)
+		close(gone)
+	}()
+	return gone
+}
+
+func (s *Server) deviceByID(id int) *Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range s.devices {
+		if d.TransportID == id {
+			return d
+		}
+	}
+	return nil
+}
+
+func (s *Server) formatDevices(long bool) string {
+	var b strings.Builder
+	for _, d := range s.Devices() {
+		state := d.getState()
+		if !long {
+			fmt.Fprintf(&b, "%s\t%s\n", d.Serial, state)
+			continue
+		}
+		fmt.Fprintf(&b, "%-22s %s", d.Serial, state)
+		if d.DevPath != "" {
+			fmt.Fprintf(&b, " %s", d.DevPath)
+		}
+		if state == "device" {
+			fmt.Fprintf(&b, " product:%s model:%s device:%s", d.Product, d.Model, d.DeviceName)
+		}
+		fmt.Fprintf(&b, " transport_id:%d\n", d.TransportID)
+	}
+	return b.String()
+}
+
+// readRequest 读取 4 位十六进制长度前缀的请求
+func readRequest(r io.Reader) (string, error) {
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return "", err
+	}
+	size, err := strconv.ParseUint(string(head), 16, 32)
+	if err != nil {
+		return "", err
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+func writeOkay(w io.Writer) {
+	_, _ = io.WriteString(w, adbutils.OKAY)
+}
+
+func writeOkayBlock(w io.Writer, msg string) {
+	_, _ = fmt.Fprintf(w, "%s%04x%s", adbutils.OKAY, len(msg), msg)
+}
+
+func writeBlock(w io.Writer, msg string) {
+	_, _ = fmt.Fprintf(w, "%04x%s", len(msg), msg)
+}
+
+func writeFail(w io.Writer, msg string) {
+	_, _ = fmt.Fprintf(w, "%s%04x%s", adbutils.FAIL, len(msg), msg)
+}
@@ -0,0 +1,250 @@
+package adbutils
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"unicode/utf16"
+)
+
+// ApkInfo
+// @Description: 从 AndroidManifest.xml 中读取的 apk 基本信息
+type ApkInfo struct {
+	Package     string
+	VersionCode int64
+	VersionName string
+	Split       string // split apk 的名称, base apk 为空
+}
+
+// 二进制 xml 中的 chunk 类型
+const (
+	axmlStringPool  = 0x0001
+	axmlFile        = 0x0003
+	axmlResourceMap = 0x0180
+	axmlStartElem   = 0x0102
+)
+
+// manifest 属性的资源 id, 混淆后的 apk 可能没有属性名, 需要按资源 id 匹配
+const (
+	resVersionCode = 0x0101021b
+	resVersionName = 0x0101021c
+)
+
+var errInvalidManifest = errors.New("adb: invalid AndroidManifest.xml")
+
+// ParseApk
+//
+//	@Description: 读取 apk 中的包名、版本和 split 名称, 不依赖 aapt
+//	@param path
+//	@return *ApkInfo
+//	@return error
+func ParseApk(path string) (*ApkInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return ParseApkReader(f, st.Size())
+}
+
+// ParseApkReader 同 ParseApk, 从内存或其他 io.ReaderAt 中读取
+func ParseApkReader(r io.ReaderAt, size int64) (*ApkInfo, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range zr.File {
+		if f.Name != "AndroidManifest.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		return parseManifest(data)
+	}
+	return nil, fmt.Errorf("adb: AndroidManifest.xml not found in apk")
+}
+
+// parseManifest 解析二进制 xml, 只读取第一个元素 manifest 的属性
+func parseManifest(data []byte) (*ApkInfo, error) {
+	le := binary.LittleEndian
+	if len(data) < 8 || le.Uint16(data) != axmlFile {
+		return nil, errInvalidManifest
+	}
+	var pool []string
+	var resIDs []uint32
+	for off := int(le.Uint16(data[2:])); off+8 <= len(data); {
+		chunkType, headerSize, size := le.Uint16(data[off:]), int(le.Uint16(data[off+2:])), int(le.Uint32(data[off+4:]))
+		if size < 8 || off+size > len(data) {
+			return nil, errInvalidManifest
+		}
+		chunk := data[off : off+size]
+		switch chunkType {
+		case axmlStringPool:
+			var err error
+			if pool, err = parseStringPool(chunk); err != nil {
+				return nil, err
+			}
+		case axmlResourceMap:
+			for i := headerSize; i+4 <= size; i += 4 {
+				resIDs = append(resIDs, le.Uint32(chunk[i:]))
+			}
+		case axmlStartElem:
+			return parseManifestAttrs(chunk, headerSize, pool, resIDs)
+		}
+		off += size
+	}
+	return nil, errInvalidManifest
+}
+
+func parseManifestAttrs(chunk []byte, headerSize int, pool []string, resIDs []uint32) (*ApkInfo, error) {
+	le := binary.LittleEndian
+	str := func(i uint32) string {
+		if int(i) < len(pool) {
+			return pool[i]
+		}
+		return ""
+	}
+	if headerSize+20 > len(chunk) {
+		return nil, errInvalidManifest
+	}
+	ext := chunk[headerSize:]
+	if str(le.Uint32(ext[4:])) != "manifest" {
+		return nil, errInvalidManifest
+	}
+	start, attrSize, count := int(le.Uint16(ext[8:])), int(le.Uint16(ext[10:])), int(le.Uint16(ext[12:]))
+	info := &ApkInfo{}
+	for i := 0; i < count; i++ {
+		off := start + i*attrSize
+		if attrSize < 20 || off+20 > len(ext) {
+			return nil, errInvalidManifest
+		}
+		attr := ext[off:]
+		nameIdx, raw, dataType, value := le.Uint32(attr[4:]), le.Uint32(attr[8:]), attr[15], le.Uint32(attr[16:])
+		name := str(nameIdx)
+		if int(nameIdx) < len(resIDs) {
+			switch resIDs[nameIdx] {
+			case resVersionCode:
+				name = "versionCode"
+			case resVersionName:
+				name = "versionName"
+			}
+		}
+		// rawValue 为 0xffffffff 时值在 typedValue 中, 类型 0x03 为字符串
+		text := str(raw)
+		if raw == 0xffffffff && dataType == 0x03 {
+			text = str(value)
+		}
+		switch name {
+		case "package":
+			info.Package = text
+		case "split":
+			info.Split = text
+		case "versionName":
+			info.VersionName = text
+		case "versionCode":
+			info.VersionCode = int64(value)
+		}
+	}
+	if info.Package == "" {
+		return nil, errInvalidManifest
+	}
+	return info, nil
+}
+
+// parseStringPool 解析字符串池, 支持 UTF-8 和 UTF-16 两种编码
+func parseStringPool(chunk []byte) ([]string, error) {
+	le := binary.LittleEndian
+	if len(chunk) < 28 {
+		return nil, errInvalidManifest
+	}
+	headerSize := int(le.Uint16(chunk[2:]))
+	count := int(le.Uint32(chunk[8:]))
+	isUTF8 := le.Uint32(chunk[16:])&(1<<8) != 0
+	stringsStart := int(le.Uint32(chunk[20:]))
+	if headerSize+4*count > len(chunk) || stringsStart > len(chunk) {
+		return nil, errInvalidManifest
+	}
+	result := make([]string, count)
+	for i := range result {
+		off := stringsStart + int(le.Uint32(chunk[headerSize+4*i:]))
+		var s string
+		var ok bool
+		if isUTF8 {
+			s, ok = decodeUTF8String(chunk, off)
+		} else {
+			s, ok = decodeUTF16String(chunk, off)
+		}
+		if !ok {
+			return nil, errInvalidManifest
+		}
+		result[i] = s
+	}
+	return result, nil
+}
+
+// decodeUTF8String 依次为 UTF-16 长度、UTF-8 字节数(各占 1 或 2 字节)和内容
+func decodeUTF8String(b []byte, off int) (string, bool) {
+	length := func() (int, bool) {
+		if off >= len(b) {
+			return 0, false
+		}
+		n := int(b[off])
+		off++
+		if n&0x80 != 0 {
+			if off >= len(b) {
+				return 0, false
+			}
+			n = (n&0x7f)<<8 | int(b[off])
+			off++
+		}
+		return n, true
+	}
+	if _, ok := length(); !ok {
+		return "", false
+	}
+	n, ok := length()
+	if !ok || off+n > len(b) {
+		return "", false
+	}
+	return string(b[off : off+n]), true
+}
+
+// decodeUTF16String 长度为 UTF-16 字符数, 占 2 或 4 字节
+func decodeUTF16String(b []byte, off int) (string, bool) {
+	le := binary.LittleEndian
+	if off+2 > len(b) {
+		return "", false
+	}
+	n := int(le.Uint16(b[off:]))
+	off += 2
+	if n&0x8000 != 0 {
+		if off+2 > len(b) {
+			return "", false
+		}
+		n = (n&0x7fff)<<16 | int(le.Uint16(b[off:]))
+		off += 2
+	}
+	if off+2*n > len(b) {
+		return "", false
+	}
+	chars := make([]uint16, n)
+	for i := range chars {
+		chars[i] = le.Uint16(b[off+2*i:])
+	}
+	return string(utf16.Decode(chars)), true
+}
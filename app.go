@@ -0,0 +1,68 @@
+package adbutils
+
+import (
+	"context"
+	"regexp"
+)
+
+var (
+	// mCurrentFocus=Window{4b2f9d1 u0 com.android.settings/com.android.settings.Settings}
+	// mFocusedApp=ActivityRecord{d3a1b3c u0 com.example/.MainActivity t12} (Android 10 以上)
+	// mFocusedApp=AppWindowToken{... token=Token{... ActivityRecord{d3a1b3c u0 com.example/.MainActivity t12}}} (Android 9 以下)
+	windowFocusRe = regexp.MustCompile(`(mCurrentFocus|mFocusedApp)=.*?\bu\d+ ([^\s/{}]+)/([^\s{}]+)`)
+	// mResumedActivity: ActivityRecord{...} (Android 9 以下), topResumedActivity=ActivityRecord{...} (Android 10 以上),
+	// ResumedActivity: ActivityRecord{...} (Android 12 以上)
+	resumedActivityRe = regexp.MustCompile(`(?:mResumedActivity|ResumedActivity)[:=]\s*ActivityRecord\{\S+ u\d+ ([^\s/{}]+)/([^\s{}]+)`)
+)
+
+// CurrentApp
+//
+//	@Description: 返回前台应用的包名、Activity 和进程 id, 先读取 dumpsys window 中的 mCurrentFocus/mFocusedApp,
+//	找不到时读取 dumpsys activity activities 中的 resumed activity; 锁屏等没有前台应用时返回 ErrNoCurrentApp.
+//	Activity 与 dumpsys 的输出一致, 可能是 .MainActivity 这样的简写
+//	@receiver mixin
+//	@param ctx
+//	@return *RunningAppInfo
+//	@return error
+func (mixin ShellMixin) CurrentApp(ctx context.Context) (*RunningAppInfo, error) {
+	output, err := mixin.runContext(ctx, "dumpsys window")
+	if err != nil {
+		return nil, err
+	}
+	info := parseWindowFocus(output)
+	if info == nil {
+		if output, err = mixin.runContext(ctx, "dumpsys activity activities"); err != nil {
+			return nil, err
+		}
+		if m := resumedActivityRe.FindStringSubmatch(output); m != nil {
+			info = &RunningAppInfo{Package: m[1], Activity: m[2]}
+		}
+	}
+	if info == nil {
+		return nil, ErrNoCurrentApp
+	}
+	// 进程可能刚好退出, 此时 Pid 为 0
+	pids, err := mixin.Pidof(ctx, info.Package)
+	if err != nil {
+		return nil, err
+	}
+	if len(pids) > 0 {
+		info.Pid = pids[0]
+	}
+	return info, nil
+}
+
+// parseWindowFocus mCurrentFocus 是弹窗或状态栏时没有 Activity, 使用 mFocusedApp
+func parseWindowFocus(output string) *RunningAppInfo {
+	var focusedApp *RunningAppInfo
+	for _, m := range windowFocusRe.FindAllStringSubmatch(output, -1) {
+		info := &RunningAppInfo{Package: m[2], Activity: m[3]}
+		if m[1] == "mCurrentFocus" {
+			return info
+		}
+		if focusedApp == nil {
+			focusedApp = info
+		}
+	}
+	return focusedApp
+}
@@ -0,0 +1,334 @@
+package adbutils
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 与 bundletool 一致的屏幕密度名称
+var densityDpi = map[string]int{
+	"ldpi":    120,
+	"mdpi":    160,
+	"tvdpi":   213,
+	"hdpi":    240,
+	"xhdpi":   320,
+	"xxhdpi":  480,
+	"xxxhdpi": 640,
+}
+
+// DeviceSpec
+// @Description: 选择 split apk 时使用的设备信息
+type DeviceSpec struct {
+	ABIs    []string // 按优先级排列, 如 [arm64-v8a armeabi-v7a armeabi]
+	Density int      // 屏幕密度 dpi, 如 440
+	Locales []string // 如 [zh-CN], 只比较语言部分
+}
+
+// DeviceSpec
+//
+//	@Description: 通过 getprop 读取设备的 abi 列表、屏幕密度和语言
+//	@receiver adbDevice
+//	@param ctx
+//	@return DeviceSpec
+//	@return error
+func (adbDevice AdbDevice) DeviceSpec(ctx context.Context) (DeviceSpec, error) {
+	var spec DeviceSpec
+	prop := func(names ...string) (string, error) {
+		for _, name := range names {
+			value, err := adbDevice.GetPropContext(ctx, name)
+			if err != nil || value != "" {
+				return value, err
+			}
+		}
+		return "", nil
+	}
+	abis, err := prop("ro.product.cpu.abilist", "ro.product.cpu.abi")
+	if err != nil {
+		return spec, err
+	}
+	for _, abi := range strings.Split(abis, ",") {
+		if abi = strings.TrimSpace(abi); abi != "" {
+			spec.ABIs = append(spec.ABIs, abi)
+		}
+	}
+	density, err := prop("ro.sf.lcd_density", "qemu.sf.lcd_density")
+	if err != nil {
+		return spec, err
+	}
+	spec.Density, _ = strconv.Atoi(density)
+	locale, err := prop("persist.sys.locale", "ro.product.locale", "persist.sys.language")
+	if err != nil {
+		return spec, err
+	}
+	if locale != "" {
+		spec.Locales = []string{locale}
+	}
+	return spec, nil
+}
+
+// SelectSplits
+//
+//	@Description: 从 base apk 和 split apk 中选出适合设备的一组: base 和功能模块全部保留,
+//	config 分包中每个模块只保留最匹配的 abi 和密度, 以及与设备语言相同的语言包
+//	@param apks 本地 apk 路径
+//	@param spec
+//	@return []string
+//	@return error
+func SelectSplits(apks []string, spec DeviceSpec) ([]string, error) {
+	type config struct {
+		apk, module, qualifier string
+	}
+	var selected []string
+	var configs []config
+	hasBase := false
+	for _, apk := range apks {
+		info, err := ParseApk(apk)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", apk, err)
+		}
+		// config 分包的名称为 config.<qualifier> 或 <module>.config.<qualifier>
+		module, qualifier := "", ""
+		if strings.HasPrefix(info.Split, "config.") {
+			qualifier = strings.TrimPrefix(info.Split, "config.")
+		} else if i := strings.Index(info.Split, ".config."); i >= 0 {
+			module, qualifier = info.Split[:i], info.Split[i+len(".config."):]
+		} else {
+			hasBase = hasBase || info.Split == ""
+			selected = append(selected, apk)
+			continue
+		}
+		configs = append(configs, config{apk, module, qualifier})
+	}
+	if !hasBase {
+		return nil, fmt.Errorf("adb: base apk not found")
+	}
+
+	// 每个模块选一个 abi 和一个密度
+	bestABI := map[string]config{}
+	bestDensity := map[string]config{}
+	abiRank := func(qualifier string) int {
+		for i, abi := range spec.ABIs {
+			if strings.Replace(abi, "-", "_", -1) == qualifier {
+				return i
+			}
+		}
+		return -1
+	}
+	densityBetter := func(a, b int) bool {
+		// 优先选不小于设备密度中最小的, 都小于时选最大的
+		if (a >= spec.Density) != (b >= spec.Density) {
+			return a >= spec.Density
+		}
+		if a >= spec.Density {
+			return a < b
+		}
+		return a > b
+	}
+	languages := map[string]bool{}
+	for _, locale := range spec.Locales {
+		// 只取语言部分, 空字符串等无效的值忽略
+		if parts := strings.FieldsFunc(locale, func(r rune) bool { return r == '-' || r == '_' }); len(parts) > 0 {
+			languages[strings.ToLower(parts[0])] = true
+		}
+	}
+	for _, c := range configs {
+		if dpi, ok := densityDpi[c.qualifier]; ok {
+			if old, ok := bestDensity[c.module]; !ok || densityBetter(dpi, densityDpi[old.qualifier]) {
+				bestDensity[c.module] = c
+			}
+			continue
+		}
+		if rank := abiRank(c.qualifier); rank >= 0 {
+			if old, ok := bestABI[c.module]; !ok || rank < abiRank(old.qualifier) {
+				bestABI[c.module] = c
+			}
+			continue
+		}
+		if isABI(c.qualifier) {
+			continue
+		}
+		// 其余为语言包, 如 config.en、config.zh
+		if languages[strings.ToLower(c.qualifier)] {
+			selected = append(selected, c.apk)
+		}
+	}
+	for _, m := range []map[string]config{bestABI, bestDensity} {
+		for _, c := range m {
+			selected = append(selected, c.apk)
+		}
+	}
+	sort.Strings(selected)
+	return selected, nil
+}
+
+// isABI 判断 split 名称是否为 abi, 设备不支持的 abi 分包不能安装
+func isABI(qualifier string) bool {
+	switch qualifier {
+	case "armeabi", "armeabi_v7a", "arm64_v8a", "x86", "x86_64", "mips", "mips64", "riscv64":
+		return true
+	}
+	return false
+}
+
+// XapkExpansion
+// @Description: xapk 的 manifest.json 中的 obb 等扩展文件
+type XapkExpansion struct {
+	File            string `json:"file"`
+	InstallLocation string `json:"install_location"`
+	InstallPath     string `json:"install_path"` // 相对外部存储的路径, 如 Android/obb/<包名>/main.1.<包名>.obb
+}
+
+// Bundle
+// @Description: 解压后的 .apks/.xapk
+type Bundle struct {
+	Apks       []string        // 解压后的 apk 路径
+	Expansions []XapkExpansion // xapk 中的扩展文件, File 为解压后的路径
+}
+
+// UnpackBundle
+//
+//	@Description: 解压 bundletool 生成的 .apks 或 .xapk 中的 apk 到 dir,
+//	.apks 中的 standalones 目录用于 Android 5.0 以下, 不会解压
+//	@param bundle
+//	@param dir
+//	@return *Bundle
+//	@return error
+func UnpackBundle(bundle, dir string) (*Bundle, error) {
+	zr, err := zip.OpenReader(bundle)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	result := &Bundle{}
+	files := map[string]string{}
+	var manifest struct {
+		Expansions []XapkExpansion `json:"expansions"`
+	}
+	for i, f := range zr.File {
+		name := path.Clean(f.Name)
+		if f.FileInfo().IsDir() || strings.HasPrefix(name, "standalones/") {
+			continue
+		}
+		if name == "manifest.json" {
+			if err := readZipJSON(f, &manifest); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if path.Ext(name) != ".apk" && path.Ext(name) != ".obb" {
+			continue
+		}
+		// 不使用压缩包中的路径, 避免 ../ 写到 dir 之外
+		local := filepath.Join(dir, fmt.Sprintf("%d_%s", i, path.Base(name)))
+		if err := extractZipFile(f, local); err != nil {
+			return nil, err
+		}
+		files[name] = local
+		if path.Ext(name) == ".apk" {
+			result.Apks = append(result.Apks, local)
+		}
+	}
+	for _, e := range manifest.Expansions {
+		local, ok := files[path.Clean(e.File)]
+		if !ok {
+			return nil, fmt.Errorf("adb: expansion %s not found in %s", e.File, bundle)
+		}
+		e.File = local
+		result.Expansions = append(result.Expansions, e)
+	}
+	if len(result.Apks) == 0 {
+		return nil, fmt.Errorf("adb: no apk found in %s", bundle)
+	}
+	return result, nil
+}
+
+func readZipJSON(f *zip.File, v interface{}) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return json.NewDecoder(rc).Decode(v)
+}
+
+func extractZipFile(f *zip.File, local string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	w, err := os.Create(local)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, rc); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// InstallBundle
+//
+//	@Description: 安装 .apks 或 .xapk: 解压到临时目录, 按 DeviceSpec 选择 split apk 后通过 InstallMultiple 一次安装,
+//	xapk 中的 obb 等扩展文件在安装成功后推送到 /sdcard 下 install_path 指定的位置
+//	@receiver adbDevice
+//	@param ctx
+//	@param bundle
+//	@param opts
+//	@return error
+func (adbDevice AdbDevice) InstallBundle(ctx context.Context, bundle string, opts InstallOptions) error {
+	dir, err := ioutil.TempDir("", "adbutils-bundle-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	b, err := UnpackBundle(bundle, dir)
+	if err != nil {
+		return err
+	}
+	apks := b.Apks
+	if len(apks) > 1 {
+		spec, err := adbDevice.DeviceSpec(ctx)
+		if err != nil {
+			return err
+		}
+		if apks, err = SelectSplits(apks, spec); err != nil {
+			return err
+		}
+	}
+	// 启动前先推送扩展文件
+	launch := opts.Launch
+	opts.Launch = false
+	if err := adbDevice.InstallMultiple(ctx, apks, opts); err != nil {
+		return err
+	}
+	for _, e := range b.Expansions {
+		remote := path.Join("/sdcard", path.Clean("/"+e.InstallPath))
+		if err := adbDevice.Sync().mkdirs(ctx, []string{path.Dir(remote)}); err != nil {
+			return err
+		}
+		if _, err := adbDevice.Sync().PushFile(ctx, e.File, remote, PushOptions{Mode: 0644, Verify: opts.Verify}); err != nil {
+			return err
+		}
+	}
+	if !launch {
+		return nil
+	}
+	// split apk 的包名与 base 相同, 任取一个即可
+	info, err := ParseApk(apks[0])
+	if err != nil {
+		return err
+	}
+	return adbDevice.launch(ctx, info.Package)
+}
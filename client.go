@@ -1,7 +1,7 @@
 package adbutils
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	_ "github.com/youluo1230/adbutils/binaries"
@@ -14,7 +14,6 @@ import (
 	"path"
 	"path/filepath"
 	"reflect"
-	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
@@ -38,11 +37,36 @@ const (
 	Windows         = "windows"
 	Mac             = "darwin"
 	Linux           = "linux"
+	StateDevice     = "device"
+	StateRecovery   = "recovery"
+	StateRescue     = "rescue"
+	StateSideload   = "sideload"
+	StateBootloader = "bootloader"
+	StateDisconnect = "disconnect"
 	macAdbURL       = "https://cdn.mongona.com/mac/adb"
 	linuxAdbURL     = "https://cdn.mongona.com/linux/adb"
 	WinAdbURL       = "https://cdn.mongona.com/win"
 )
 
+// wait-for 服务的连接方式
+const (
+	TransportAny   = "any"
+	TransportUSB   = "usb"
+	TransportLocal = "local" // 模拟器和 adb connect 的设备
+)
+
+// bootCompletedInterval WaitForBootCompleted 轮询 sys.boot_completed 的间隔
+const bootCompletedInterval = 500 * time.Millisecond
+
+var waitForStates = map[string]bool{
+	StateDevice:     true,
+	StateRecovery:   true,
+	StateRescue:     true,
+	StateSideload:   true,
+	StateBootloader: true,
+	StateDisconnect: true,
+}
+
 func checkServer(host string, port int) bool {
 	_, err := net.Dial("tcp", fmt.Sprintf("%v:%v", host, port))
 	return err == nil
@@ -86,25 +110,33 @@ type AdbConnection struct {
 	Host string
 	Port int
 	Conn net.Conn
+	// 最近一次发送的服务, 用于填充 AdbError
+	service string
 }
 
-func (adbConnection AdbConnection) safeConnect(t time.Duration) (*net.Conn, error) {
-	conn, err := adbConnection.createSocket(t)
+func (adbConnection *AdbConnection) safeConnect(ctx context.Context, t time.Duration) (*net.Conn, error) {
+	conn, err := adbConnection.createSocket(ctx, t)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		switch reflect.TypeOf(err) {
 		case reflect.TypeOf(&net.OpError{}):
-			cmd := exec.Command(AdbPath(), "start-server")
+			cmd := exec.CommandContext(ctx, AdbPath(), "start-server")
 			err = cmd.Start()
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("%w: %v", ErrServerUnreachable, err)
 			}
 			err = cmd.Wait()
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("%w: %v", ErrServerUnreachable, err)
 			}
-			conn, err = adbConnection.createSocket(t)
+			conn, err = adbConnection.createSocket(ctx, t)
 			if err != nil {
-				return nil, err
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				return nil, fmt.Errorf("%w: %v", ErrServerUnreachable, err)
 			}
 			return conn, nil
 		default:
@@ -114,99 +146,143 @@ func (adbConnection AdbConnection) safeConnect(t time.Duration) (*net.Conn, erro
 	return conn, nil
 }
 
-func (adbConnection AdbConnection) SetTimeout(timeOut time.Duration) error {
+func (adbConnection *AdbConnection) SetTimeout(timeOut time.Duration) error {
 	if timeOut != 0 {
-		var err error
-		err = adbConnection.Conn.SetDeadline(time.Now().Add(time.Second * timeOut))
-		if err != nil {
-			panic(err.Error())
-			return err
-		}
+		return adbConnection.Conn.SetDeadline(time.Now().Add(timeOut))
 	}
 	return nil
 }
 
-func (adbConnection AdbConnection) createSocket(t time.Duration) (*net.Conn, error) {
+func (adbConnection *AdbConnection) createSocket(ctx context.Context, t time.Duration) (*net.Conn, error) {
 	dialer := net.Dialer{
 		Timeout: t,
 	}
-	conn, err := dialer.Dial("tcp", fmt.Sprintf("%v:%d", adbConnection.Host, adbConnection.Port))
+	conn, err := dialer.DialContext(ctx, "tcp", fmt.Sprintf("%v:%d", adbConnection.Host, adbConnection.Port))
 	if err != nil {
 		return nil, err
 	}
 	return &conn, nil
 }
 
-func (adbConnection AdbConnection) Close() {
+func (adbConnection *AdbConnection) Close() {
 	err := adbConnection.Conn.Close()
 	if err != nil {
 		return
 	}
 }
 
-func (adbConnection AdbConnection) Read(n int) []byte {
+func (adbConnection *AdbConnection) Read(n int) ([]byte, error) {
 	return adbConnection.readFully(n)
 }
 
-func (adbConnection AdbConnection) readFully(n int) []byte {
-	t := 0
+// readFully
+//
+//	@Description: 读满 n 个字节, 连接提前关闭时返回已读内容和 io.ErrUnexpectedEOF
+//	@receiver adbConnection
+//	@param n
+//	@return []byte
+//	@return error
+func (adbConnection *AdbConnection) readFully(n int) ([]byte, error) {
 	buffer := make([]byte, n)
-	result := bytes.NewBuffer(nil)
-	for t < n {
-		length, err := adbConnection.Conn.Read(buffer[t:n])
-		result.Write(buffer[:length])
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			break
-		}
-		if length == 0 {
-			break
-		}
-		t += length
-	}
-	return result.Bytes()
+	t, err := io.ReadFull(adbConnection.Conn, buffer)
+	return buffer[:t], err
 }
 
-func (adbConnection AdbConnection) SendCommand(cmd string) {
+func (adbConnection *AdbConnection) SendCommand(cmd string) error {
+	adbConnection.service = cmd
 	msg := fmt.Sprintf("%04x%s", len(cmd), cmd)
 	_, err := adbConnection.Conn.Write([]byte(msg))
+	return err
+}
+
+func (adbConnection *AdbConnection) ReadString(n int) (string, error) {
+	res, err := adbConnection.Read(n)
+	return strings.TrimSpace(string(res)), err
+}
+
+func (adbConnection *AdbConnection) ReadStringBlock() (string, error) {
+	str, err := adbConnection.ReadString(4)
 	if err != nil {
-		log.Println("write error!", err.Error())
-		return
+		return "", err
 	}
+	size, err := strconv.ParseUint(str, 16, 32)
+	if err != nil {
+		return "", fmt.Errorf("adb: invalid length prefix %q", str)
+	}
+	return adbConnection.ReadString(int(size))
 }
 
-func (adbConnection AdbConnection) ReadString(n int) string {
-	res := adbConnection.Read(n)
-	return strings.TrimSpace(string(res))
+func (adbConnection *AdbConnection) ReadUntilClose() (string, error) {
+	buf, err := io.ReadAll(adbConnection.Conn)
+	return string(buf), err
 }
-func (adbConnection AdbConnection) ReadStringBlock() string {
-	str := adbConnection.ReadString(4)
-	size, _ := strconv.ParseUint(str, 16, 32)
-	return adbConnection.ReadString(int(size))
+
+// CheckOkay
+//
+//	@Description: 读取服务端状态, FAIL 时解析其后的错误信息并返回 *AdbError
+//	@receiver adbConnection
+//	@return error
+func (adbConnection *AdbConnection) CheckOkay() error {
+	data, err := adbConnection.ReadString(4)
+	if err != nil {
+		return err
+	}
+	switch data {
+	case OKAY:
+		return nil
+	case FAIL:
+		msg, err := adbConnection.ReadStringBlock()
+		if err != nil {
+			return err
+		}
+		return &AdbError{Service: adbConnection.service, Message: msg}
+	}
+	return fmt.Errorf("adb: unexpected response %q for %s", data, adbConnection.service)
 }
 
-func (adbConnection AdbConnection) ReadUntilClose() string {
-	buf := []byte{}
-	for {
-		chunk := adbConnection.Read(4096)
-		if len(chunk) == 0 {
-			break
+// contextConn
+// @Description: ctx 结束时关闭底层连接, 打断阻塞在读写上的调用; Close 后监听协程随之退出
+type contextConn struct {
+	net.Conn
+	ctx  context.Context
+	stop chan struct{}
+	once sync.Once
+}
+
+func watchConn(ctx context.Context, conn net.Conn) net.Conn {
+	if ctx.Done() == nil {
+		return conn
+	}
+	c := &contextConn{Conn: conn, ctx: ctx, stop: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-c.stop:
 		}
+	}()
+	return c
+}
 
-		buf = append(buf, chunk...)
+func (c *contextConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil && c.ctx.Err() != nil {
+		err = c.ctx.Err()
 	}
-	return string(buf)
+	return n, err
 }
 
-func (adbConnection AdbConnection) CheckOkay() bool {
-	data := adbConnection.ReadString(4)
-	if data == OKAY {
-		return true
+func (c *contextConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if err != nil && c.ctx.Err() != nil {
+		err = c.ctx.Err()
 	}
-	return false
+	return n, err
+}
+
+func (c *contextConn) Close() error {
+	c.once.Do(func() { close(c.stop) })
+	return c.Conn.Close()
 }
 
 // end region AdbConnection
@@ -224,10 +300,7 @@ func downloadFile(url string, localPath string, wg *sync.WaitGroup) error {
 			wg.Done()
 		}
 	}()
-	var (
-		buf     = make([]byte, 32*1024)
-		written int64
-	)
+	buf := make([]byte, 32*1024)
 	tmpFilePath := localPath + ".download"
 	client := new(http.Client)
 	resp, err := client.Get(url)
@@ -239,10 +312,6 @@ func downloadFile(url string, localPath string, wg *sync.WaitGroup) error {
 	if err != nil {
 		return err
 	}
-	fileSize, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 32)
-	if err != nil {
-		log.Println(err.Error())
-	}
 	defer resp.Body.Close()
 	if resp.Body == nil {
 		return errors.New("body is null")
@@ -251,10 +320,6 @@ func downloadFile(url string, localPath string, wg *sync.WaitGroup) error {
 		nr, er := resp.Body.Read(buf)
 		if nr > 0 {
 			nw, ew := file.Write(buf[0:nr])
-			log.Println(fmt.Sprintf("Download %v Done:%dKb, Total:%dKb, Process:%.2f", url, written/1024, fileSize, float32(written)/float32(fileSize)))
-			if nw > 0 {
-				written += int64(nw)
-			}
 			if ew != nil {
 				err = ew
 				break
@@ -354,75 +419,244 @@ func AdbPath() string {
 }
 
 func (adb *AdbClient) connect() (*AdbConnection, error) {
+	return adb.connectContext(context.Background())
+}
+
+// connectContext
+//
+//	@Description: 建立到adb服务端的连接, ctx 取消或超时会关闭该连接
+//	@receiver adb
+//	@param ctx
+//	@return *AdbConnection
+//	@return error
+func (adb *AdbClient) connectContext(ctx context.Context) (*AdbConnection, error) {
 	adbConnection := &AdbConnection{
 		Host: adb.Host,
 		Port: adb.Port,
 	}
-	conn, err := adbConnection.safeConnect(adb.SocketTime)
+	conn, err := adbConnection.safeConnect(ctx, adb.SocketTime)
 	if err != nil {
 		return nil, err
 	}
-	adbConnection.Conn = *conn
+	adbConnection.Conn = watchConn(ctx, *conn)
 	return adbConnection, nil
+}
 
+// hostCommand
+//
+//	@Description: 执行 host 服务并读取长度前缀的返回内容
+//	@receiver adb
+//	@param ctx
+//	@param cmd
+//	@return string
+//	@return error
+func (adb *AdbClient) hostCommand(ctx context.Context, cmd string) (string, error) {
+	c, err := adb.connectContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	if err = c.SendCommand(cmd); err != nil {
+		return "", err
+	}
+	if err = c.CheckOkay(); err != nil {
+		return "", err
+	}
+	return c.ReadStringBlock()
+}
+
+// dialContext
+//
+//	@Description: 只建立连接, 与 connectContext 不同的是服务端不可达时不会尝试启动 adb server
+//	@receiver adb
+//	@param ctx
+//	@return *AdbConnection
+//	@return error
+func (adb *AdbClient) dialContext(ctx context.Context) (*AdbConnection, error) {
+	adbConnection := &AdbConnection{
+		Host: adb.Host,
+		Port: adb.Port,
+	}
+	conn, err := adbConnection.createSocket(ctx, adb.SocketTime)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %v", ErrServerUnreachable, err)
+	}
+	adbConnection.Conn = watchConn(ctx, *conn)
+	return adbConnection, nil
 }
 
 func (adb *AdbClient) ServerVersion() (int, error) {
-	c, err := adb.connect()
+	return adb.ServerVersionContext(context.Background())
+}
+
+func (adb *AdbClient) ServerVersionContext(ctx context.Context) (int, error) {
+	res, err := adb.hostCommand(ctx, "host:version")
 	if err != nil {
 		return 0, err
 	}
-	defer c.Close()
-	c.SendCommand("host:version")
-	c.CheckOkay()
-	res := c.ReadStringBlock()
-	l, _ := strconv.Atoi(res)
-	return l + 16, nil
+	version, err := strconv.ParseInt(res, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("adb: invalid server version %q", res)
+	}
+	return int(version), nil
 }
 
 func (adb *AdbClient) ServerKill() error {
+	return adb.ServerKillContext(context.Background())
+}
+
+func (adb *AdbClient) ServerKillContext(ctx context.Context) error {
 	if checkServer(adb.Host, adb.Port) {
-		c, err := adb.connect()
+		c, err := adb.connectContext(ctx)
 		if err != nil {
 			return err
 		}
 		defer c.Close()
-		c.SendCommand("host:kill")
-		c.CheckOkay()
+		if err = c.SendCommand("host:kill"); err != nil {
+			return err
+		}
+		return c.CheckOkay()
 	}
-	return nil
+	return ctx.Err()
 }
 
-func (adb *AdbClient) WaitFor() {
-	// pass
+// WaitFor
+//
+//	@Description: 阻塞直到设备进入指定状态, 基于 host-serial:<serial>:wait-for-any-<state> 服务;
+//	serial 为空时等待任意设备. state 可为 device、recovery、rescue、sideload、bootloader、disconnect
+//	@receiver adb
+//	@param ctx
+//	@param serial
+//	@param state
+//	@return error
+func (adb *AdbClient) WaitFor(ctx context.Context, serial, state string) error {
+	return adb.WaitForTransport(ctx, serial, TransportAny, state)
 }
 
-func (adb *AdbClient) Connect(addr string) bool {
-	//addr (str): adb remote address [eg: 191.168.0.1:5555]
-	c, err := adb.connect()
+// WaitForTransport
+//
+//	@Description: 同 WaitFor, 只等待通过指定方式连接的设备
+//	@receiver adb
+//	@param ctx
+//	@param serial
+//	@param transport TransportAny、TransportUSB 或 TransportLocal, 为空时同 TransportAny
+//	@param state
+//	@return error
+func (adb *AdbClient) WaitForTransport(ctx context.Context, serial, transport, state string) error {
+	if !waitForStates[state] {
+		return fmt.Errorf("adb: unsupported wait-for state %q", state)
+	}
+	switch transport {
+	case "":
+		transport = TransportAny
+	case TransportAny, TransportUSB, TransportLocal:
+	default:
+		return fmt.Errorf("adb: unsupported wait-for transport %q", transport)
+	}
+	service := fmt.Sprintf("host:wait-for-%s-%s", transport, state)
+	if serial != "" {
+		service = fmt.Sprintf("host-serial:%s:wait-for-%s-%s", serial, transport, state)
+	}
+	c, err := adb.connectContext(ctx)
 	if err != nil {
-		return false
+		return err
 	}
 	defer c.Close()
-	c.SendCommand("host:connect:" + addr)
-	c.CheckOkay()
-	if strings.Index(c.ReadStringBlock(), "由于") > -1 {
-		return false
+	if err = c.SendCommand(service); err != nil {
+		return err
+	}
+	// 第一个 OKAY 表示请求被接受, 第二个表示设备已进入目标状态
+	if err = c.CheckOkay(); err != nil {
+		return err
+	}
+	return c.CheckOkay()
+}
+
+// WaitForBootCompleted
+//
+//	@Description: 等待设备上线后继续轮询 sys.boot_completed, 直到系统启动完成;
+//	serial 为空时等待任意设备, 上线后按该设备的序列号轮询
+//	@receiver adb
+//	@param ctx
+//	@param serial
+//	@return error
+func (adb *AdbClient) WaitForBootCompleted(ctx context.Context, serial string) error {
+	if err := adb.WaitFor(ctx, serial, StateDevice); err != nil {
+		return err
+	}
+	if serial == "" {
+		var err error
+		if serial, err = adb.hostCommand(ctx, "host:get-serialno"); err != nil {
+			return err
+		}
 	}
-	return true
+	device := adb.Device(SerialNTransportID{Serial: serial})
+	ticker := time.NewTicker(bootCompletedInterval)
+	defer ticker.Stop()
+	for {
+		res, err := device.runContext(ctx, "getprop sys.boot_completed")
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// 重启过程中设备可能短暂掉线, 只忽略这类错误继续轮询
+		if err != nil && !errors.Is(err, ErrDeviceOffline) && !errors.Is(err, ErrDeviceNotFound) {
+			return err
+		}
+		if err == nil && strings.TrimSpace(res) == "1" {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Connect
+//
+//	@Description: 同 adb connect, 连接失败时返回 *AdbError
+//	@receiver adb
+//	@param addr adb remote address [eg: 191.168.0.1:5555]
+//	@return string 服务端输出, 如 "already connected to 191.168.0.1:5555"
+//	@return error
+func (adb *AdbClient) Connect(addr string) (string, error) {
+	return adb.ConnectContext(context.Background(), addr)
 }
 
-func (adb *AdbClient) Disconnect(addr string, raiseErr bool) bool {
-	//addr (str): adb remote address [eg: 191.168.0.1:5555]
-	c, err := adb.connect()
+func (adb *AdbClient) ConnectContext(ctx context.Context, addr string) (string, error) {
+	service := "host:connect:" + addr
+	output, err := adb.hostCommand(ctx, service)
 	if err != nil {
-		return false
+		return "", err
 	}
-	defer c.Close()
-	c.SendCommand("host:disconnect:" + addr)
-	c.CheckOkay()
-	c.ReadStringBlock()
-	return true
+	if !strings.HasPrefix(output, "connected to") && !strings.HasPrefix(output, "already connected to") {
+		return output, &AdbError{Service: service, Message: output}
+	}
+	return output, nil
+}
+
+// Disconnect
+//
+//	@Description: 同 adb disconnect, raiseErr 为 false 时忽略服务端返回的错误(如设备不存在)
+//	@receiver adb
+//	@param addr adb remote address [eg: 191.168.0.1:5555]
+//	@param raiseErr
+//	@return error
+func (adb *AdbClient) Disconnect(addr string, raiseErr bool) error {
+	return adb.DisconnectContext(context.Background(), addr, raiseErr)
+}
+
+func (adb *AdbClient) DisconnectContext(ctx context.Context, addr string, raiseErr bool) error {
+	_, err := adb.hostCommand(ctx, "host:disconnect:"+addr)
+	var adbErr *AdbError
+	if !raiseErr && errors.As(err, &adbErr) {
+		return nil
+	}
+	return err
 }
 
 type SerialNTransportID struct {
@@ -430,32 +664,57 @@ type SerialNTransportID struct {
 	TransportID int
 }
 
-func (adb *AdbClient) Shell(serial string, command string, stream bool) interface{} {
+func (adb *AdbClient) Shell(serial string, command string) (string, error) {
+	snNtid := SerialNTransportID{Serial: serial}
+	return adb.Device(snNtid).Shell(command, adb.SocketTime)
+}
+
+func (adb *AdbClient) ShellContext(ctx context.Context, serial string, command string) (string, error) {
 	snNtid := SerialNTransportID{Serial: serial}
-	return adb.Device(snNtid).Shell(command, stream, adb.SocketTime)
+	return adb.Device(snNtid).ShellContext(ctx, command)
+}
+
+func (adb *AdbClient) DeviceList() ([]AdbDevice, error) {
+	return adb.DeviceListContext(context.Background())
 }
 
-func (adb *AdbClient) DeviceList() []AdbDevice {
+// DeviceListContext
+//
+//	@Description: 返回状态为 device 的设备, 解析 host:devices-l 中 serial、状态之后的 key:value 字段,
+//	如 usb:1-1 product:sdk_gphone64_x86_64 model:sdk_gphone64_x86_64 device:emu64xa transport_id:12
+//	@receiver adb
+//	@param ctx
+//	@return []AdbDevice
+//	@return error
+func (adb *AdbClient) DeviceListContext(ctx context.Context) ([]AdbDevice, error) {
 	var res []AdbDevice
-	c, err := adb.connect()
+	outPut, err := adb.hostCommand(ctx, "host:devices-l")
 	if err != nil {
-		return res
+		return nil, err
 	}
-	defer c.Close()
-	c.SendCommand("host:devices-l")
-	c.CheckOkay()
-	outPut := c.ReadStringBlock()
-	re := regexp.MustCompile(`(\S+)\s+device\s+product:(\S+)\s+model:(\S+)\s+device:(\S+)\s+transport_id:(\d)`)
-	outPuts := re.FindAllStringSubmatch(outPut, -1)
-	for _, line := range outPuts {
-		if len(line) != 6 {
+	for _, line := range strings.Split(outPut, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[1] != "device" {
 			continue
 		}
-		id, _ := strconv.Atoi(line[5])
-		res = append(res, AdbDevice{ShellMixin{Client: adb, Serial: line[1], TransportID: id, Model: line[3], DeviceType: line[4]}})
-
+		device := AdbDevice{ShellMixin{Client: adb, Serial: fields[0]}}
+		for _, field := range fields[2:] {
+			i := strings.Index(field, ":")
+			if i < 0 {
+				continue
+			}
+			switch value := field[i+1:]; field[:i] {
+			case "model":
+				device.Model = value
+			case "device":
+				device.DeviceType = value
+			case "transport_id":
+				device.TransportID, _ = strconv.Atoi(value)
+			}
+		}
+		res = append(res, device)
 	}
-	return res
+	return res, nil
 }
 
 func (adb *AdbClient) Device(snNtid SerialNTransportID) AdbDevice {
@@ -464,7 +723,7 @@ func (adb *AdbClient) Device(snNtid SerialNTransportID) AdbDevice {
 	}
 	serial := os.Getenv("ANDROID_SERIAL")
 	if serial != "" {
-		ds := adb.DeviceList()
+		ds, _ := adb.DeviceList()
 		if len(ds) > 0 {
 			return ds[0]
 		}
@@ -482,6 +741,10 @@ func (adb *AdbClient) ExtendedServices() (*AdbConnection, error) {
 	return adb.connect()
 }
 
+func (adb *AdbClient) ExtendedServicesContext(ctx context.Context) (*AdbConnection, error) {
+	return adb.connectContext(ctx)
+}
+
 func NewAdb(host string, port int, timeOut time.Duration) *AdbClient {
 	adb := &AdbClient{Host: host, Port: port, SocketTime: time.Second * timeOut}
 	return adb
@@ -0,0 +1,137 @@
+package adbutils
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// 压缩传输相关的设备 feature, 需要同时支持 sendrecv_v2
+const (
+	FeatureSendRecvV2Brotli = "sendrecv_v2_brotli"
+	FeatureSendRecvV2LZ4    = "sendrecv_v2_lz4"
+	FeatureSendRecvV2Zstd   = "sendrecv_v2_zstd"
+)
+
+// SND2/RCV2 的 flags
+const (
+	syncFlagBrotli uint32 = 1
+	syncFlagLZ4    uint32 = 2
+	syncFlagZstd   uint32 = 4
+)
+
+// Compression
+// @Description: Sync 传输文件时使用的压缩算法, 零值 CompressionAny 表示按设备支持情况自动选择
+type Compression int
+
+const (
+	CompressionAny Compression = iota // 与 adb 一致, 依次尝试 zstd、lz4、brotli, 都不支持时不压缩
+	CompressionNone
+	CompressionBrotli
+	CompressionLZ4
+	CompressionZstd
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionAny:
+		return "any"
+	case CompressionNone:
+		return "none"
+	case CompressionBrotli:
+		return "brotli"
+	case CompressionLZ4:
+		return "lz4"
+	case CompressionZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("Compression(%d)", int(c))
+}
+
+func (c Compression) feature() string {
+	switch c {
+	case CompressionBrotli:
+		return FeatureSendRecvV2Brotli
+	case CompressionLZ4:
+		return FeatureSendRecvV2LZ4
+	case CompressionZstd:
+		return FeatureSendRecvV2Zstd
+	}
+	return ""
+}
+
+func (c Compression) flag() uint32 {
+	switch c {
+	case CompressionBrotli:
+		return syncFlagBrotli
+	case CompressionLZ4:
+		return syncFlagLZ4
+	case CompressionZstd:
+		return syncFlagZstd
+	}
+	return 0
+}
+
+// resolveCompression
+//
+//	@Description: 根据设备 feature 决定实际使用的压缩算法, 指定的算法设备不支持时返回 ErrNotSupported
+//	@param want
+//	@param features 设备 feature, 逗号分隔
+//	@return Compression CompressionNone 表示不压缩
+//	@return error
+func resolveCompression(want Compression, features string) (Compression, error) {
+	switch want {
+	case CompressionNone:
+		return CompressionNone, nil
+	case CompressionAny:
+		if !hasFeature(features, FeatureSendRecvV2) {
+			return CompressionNone, nil
+		}
+		for _, c := range []Compression{CompressionZstd, CompressionLZ4, CompressionBrotli} {
+			if hasFeature(features, c.feature()) {
+				return c, nil
+			}
+		}
+		return CompressionNone, nil
+	case CompressionBrotli, CompressionLZ4, CompressionZstd:
+		if !hasFeature(features, FeatureSendRecvV2) || !hasFeature(features, want.feature()) {
+			return CompressionNone, fmt.Errorf("%w: %s", ErrNotSupported, want.feature())
+		}
+		return want, nil
+	}
+	return CompressionNone, fmt.Errorf("unknown compression %v", want)
+}
+
+// newWriter 返回压缩写入器, Close 时写出剩余数据但不关闭 w
+func (c Compression) newWriter(w io.Writer) (io.WriteCloser, error) {
+	switch c {
+	case CompressionBrotli:
+		return brotli.NewWriter(w), nil
+	case CompressionLZ4:
+		return lz4.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	}
+	return nil, fmt.Errorf("unknown compression %v", c)
+}
+
+// newReader 返回解压读取器, 使用完需要 Close 释放解码器
+func (c Compression) newReader(r io.Reader) (io.ReadCloser, error) {
+	switch c {
+	case CompressionBrotli:
+		return ioutil.NopCloser(brotli.NewReader(r)), nil
+	case CompressionLZ4:
+		return ioutil.NopCloser(lz4.NewReader(r)), nil
+	case CompressionZstd:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unknown compression %v", c)
+}
@@ -6,13 +6,11 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
-	"syscall"
 	"time"
 )
 
@@ -27,27 +25,31 @@ type ShellMixin struct {
 	Properties  map[string]string
 }
 
-func (mixin ShellMixin) run(cmd string) interface{} {
-	return mixin.Client.Shell(mixin.Serial, cmd, false)
+func (mixin ShellMixin) run(cmd string) (string, error) {
+	return mixin.runContext(context.Background(), cmd)
 }
 
-func (mixin ShellMixin) SayHello() string {
+func (mixin ShellMixin) runContext(ctx context.Context, cmd string) (string, error) {
+	return mixin.Client.ShellContext(ctx, mixin.Serial, cmd)
+}
+
+func (mixin ShellMixin) SayHello() (string, error) {
 	content := "hello from " + mixin.Serial
-	res := mixin.run("echo " + content)
-	return res.(string)
+	return mixin.run("echo " + content)
 }
 
-func (mixin ShellMixin) SwitchScreen(status bool) {
+func (mixin ShellMixin) SwitchScreen(status bool) error {
 	KeyMap := map[bool]string{
 		true:  "224",
 		false: "223",
 	}
-	mixin.KeyEvent(KeyMap[status])
+	_, err := mixin.KeyEvent(KeyMap[status])
+	return err
 }
 
-func (mixin ShellMixin) SwitchAirPlane(status bool) {
-	base := "settings put global airplane_mode_on"
-	am := "am broadcast -a android.intent.action.AIRPLANE_MODE --ez state"
+func (mixin ShellMixin) SwitchAirPlane(status bool) error {
+	base := "settings put global airplane_mode_on "
+	am := "am broadcast -a android.intent.action.AIRPLANE_MODE --ez state "
 	if status {
 		base += "1"
 		am += "true"
@@ -55,89 +57,84 @@ func (mixin ShellMixin) SwitchAirPlane(status bool) {
 		base += "0"
 		am += "false"
 	}
-	mixin.run(base)
-	mixin.run(am)
+	if _, err := mixin.run(base); err != nil {
+		return err
+	}
+	_, err := mixin.run(am)
+	return err
 }
 
-func (mixin ShellMixin) SwitchWifi(status bool) {
+func (mixin ShellMixin) SwitchWifi(status bool) error {
 	cmdMap := map[bool]string{
 		true:  "svc wifi enable",
 		false: "svc wifi disable",
 	}
-	mixin.run(cmdMap[status])
+	_, err := mixin.run(cmdMap[status])
+	return err
 }
 
-func (mixin ShellMixin) KeyEvent(keyCode string) string {
-	res := mixin.run("input keyevent " + keyCode)
-	return res.(string)
+func (mixin ShellMixin) KeyEvent(keyCode string) (string, error) {
+	return mixin.run("input keyevent " + keyCode)
 }
 
-func (mixin ShellMixin) CLick(x, y int) {
-	mixin.run(fmt.Sprintf("input tap %v %v", x, y))
+func (mixin ShellMixin) CLick(x, y int) error {
+	_, err := mixin.run(fmt.Sprintf("input tap %v %v", x, y))
+	return err
 }
 
-func (mixin ShellMixin) Swipe(x, y, tox, toy int, duration time.Duration) {
-	mixin.run(fmt.Sprintf("input swipe %v %v %v %v %v", x, y, tox, toy, duration*1000))
+func (mixin ShellMixin) Swipe(x, y, tox, toy int, duration time.Duration) error {
+	_, err := mixin.run(fmt.Sprintf("input swipe %v %v %v %v %v", x, y, tox, toy, duration*1000))
+	return err
 }
 
-func (mixin ShellMixin) SendKeys(text string) {
+func (mixin ShellMixin) SendKeys(text string) error {
 	// TODO escapeSpecialCharacters
-	mixin.run("input text " + text)
+	_, err := mixin.run("input text " + text)
+	return err
 }
 
 func (mixin ShellMixin) escapeSpecialCharacters(text string) {}
 
 // 有参 为otg设备检测eth0网口
-func (mixin ShellMixin) WlanIp(args ...bool) string {
+func (mixin ShellMixin) WlanIp(args ...bool) (string, error) {
 	cmd := "ifconfig wlan0 | grep -oE 'inet addr:([0-9]{1,3}\\.){3}[0-9]{1,3}' | awk '$2' | awk -F: '{print $2}'"
 	if len(args) > 0 {
 		cmd = "ifconfig eth0 | grep -oE 'inet addr:([0-9]{1,3}\\.){3}[0-9]{1,3}' | awk '$2' | awk -F: '{print $2}'"
 	}
-	res := mixin.run(cmd)
-	ipInfo := res.(string)
 	// TODO regrex
-	return ipInfo
-}
-
-func (mixin ShellMixin) install(pathOrUrl string, noLaunch bool, unInstall bool, silent bool, callBack func()) {
+	return mixin.run(cmd)
 }
 
-func (mixin ShellMixin) InstallRemote(remotePath string, clean bool) {
-	res := mixin.run("pm install -r -t " + remotePath)
-	resInfo := res.(string)
-	if !strings.Contains(resInfo, "Success") {
-		log.Println(resInfo)
-	}
+// InstallRemote
+//
+//	@Description: 安装设备上已有的 apk, 相当于 pm install -r -t, 失败时返回 *InstallError
+//	@receiver mixin
+//	@param remotePath
+//	@param clean 安装后删除 apk
+//	@return error
+func (mixin ShellMixin) InstallRemote(remotePath string, clean bool) error {
+	ctx := context.Background()
+	err := mixin.pmInstall(ctx, remotePath, InstallOptions{Replace: true, Test: true})
 	if clean {
-		mixin.run("rm " + remotePath)
+		if _, rmErr := mixin.runContext(ctx, "rm "+shellQuote(remotePath)); err == nil {
+			err = rmErr
+		}
 	}
+	return err
 }
 
-func (mixin ShellMixin) Uninstall(packageName string) {
-	mixin.run("pm uninstall " + packageName)
-}
-
-func (mixin ShellMixin) GetProp(prop string) string {
-	res := mixin.run("getprop " + prop)
-	return strings.TrimSpace(res.(string))
+func (mixin ShellMixin) Uninstall(packageName string) error {
+	_, err := mixin.run("pm uninstall " + packageName)
+	return err
 }
 
-func (mixin ShellMixin) ListPackages() []string {
-	result := []string{}
-	res := mixin.run("pm list packages")
-	output := res.(string)
-	for _, packageName := range strings.Split(output, "\n") {
-		p := strings.TrimSpace(strings.TrimPrefix(packageName, "package:"))
-		if p == "" {
-			continue
-		}
-		result = append(result, p)
-	}
-	return result
+func (mixin ShellMixin) GetProp(prop string) (string, error) {
+	return mixin.GetPropContext(context.Background(), prop)
 }
 
-func (mixin ShellMixin) PackageInfo(packageName string) {
-	// TODO
+func (mixin ShellMixin) GetPropContext(ctx context.Context, prop string) (string, error) {
+	res, err := mixin.runContext(ctx, "getprop "+prop)
+	return strings.TrimSpace(res), err
 }
 
 func (mixin ShellMixin) Rotation() {}
@@ -146,82 +143,87 @@ func (mixin ShellMixin) rawWindowSize() {}
 
 func (mixin ShellMixin) WindowSize() {}
 
-func (mixin ShellMixin) AppStart(packageName, activity string) {
+func (mixin ShellMixin) AppStart(packageName, activity string) error {
+	var err error
 	if activity != "" {
-		mixin.run("am start -n " + packageName + "/" + activity)
+		_, err = mixin.run("am start -n " + packageName + "/" + activity)
 	} else {
-		mixin.run("monkey -p " + packageName + "-c" + "android.intent.category.LAUNCHER 1")
+		_, err = mixin.run("monkey -p " + packageName + " -c android.intent.category.LAUNCHER 1")
 	}
+	return err
 }
 
-func (mixin ShellMixin) AppStop(packageName string) {
-	mixin.run("am force-stop " + packageName)
+func (mixin ShellMixin) AppStop(packageName string) error {
+	_, err := mixin.run("am force-stop " + packageName)
+	return err
 }
 
-func (mixin ShellMixin) AppClear(packageName string) {
-	mixin.run("pm clear " + packageName)
+func (mixin ShellMixin) AppClear(packageName string) error {
+	_, err := mixin.run("pm clear " + packageName)
+	return err
 }
 
-func (mixin ShellMixin) IsScreenOn() bool {
-	res := mixin.run("dumpsys power")
-	output := res.(string)
-	return strings.Contains(output, "mHoldingDisplaySuspendBlocker=true")
+func (mixin ShellMixin) IsScreenOn() (bool, error) {
+	output, err := mixin.run("dumpsys power")
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(output, "mHoldingDisplaySuspendBlocker=true"), nil
 }
 
-func (mixin ShellMixin) OpenBrowser(url string) {
-	mixin.run("am start -a android.intent.action.VIEW -d " + url)
+func (mixin ShellMixin) OpenBrowser(url string) error {
+	_, err := mixin.run("am start -a android.intent.action.VIEW -d " + url)
+	return err
 }
 
 func (mixin ShellMixin) DumpHierarchy() string {
 	return ""
 }
 
-func (mixin ShellMixin) CurrentApp() string {
-	return ""
-}
-
-func (mixin ShellMixin) Remove(path string) {
-	mixin.run("rm " + path)
+func (mixin ShellMixin) Remove(path string) error {
+	_, err := mixin.run("rm " + path)
+	return err
 }
 
-func (mixin ShellMixin) openTransport(command string, timeOut time.Duration) (*AdbConnection, error) {
-	c, err := mixin.Client.connect()
-	if err != nil {
-		return nil, err
-	}
-	if timeOut > 0 {
-		// 这里修改了一下 使用c设置Conn的timeout
-		err := c.SetTimeout(timeOut)
-		if err != nil {
-			return nil, err
-		}
-	}
+func (mixin ShellMixin) openTransport(ctx context.Context, command string, timeOut time.Duration) (*AdbConnection, error) {
+	var cmd string
 	if command != "" {
 		if mixin.TransportID > 0 {
-			c.SendCommand("host-transport-id:" + fmt.Sprintf("%d:%s", mixin.TransportID, command))
-			//  send_command(f"host-transport-id:{self._transport_id}:{command}")
+			cmd = "host-transport-id:" + fmt.Sprintf("%d:%s", mixin.TransportID, command)
 		} else if mixin.Serial != "" {
-			cmd := "host-serial:" + fmt.Sprintf("%s:%s", mixin.Serial, command)
-			c.SendCommand(cmd)
-			// c.send_command(f"host-serial:{self._serial}:{command}")
-		} else {
-			log.Println("RuntimeError")
+			cmd = "host-serial:" + fmt.Sprintf("%s:%s", mixin.Serial, command)
 		}
-		c.CheckOkay()
 	} else {
 		if mixin.TransportID > 0 {
-			c.SendCommand("host:transport-id:" + fmt.Sprintf("%d", mixin.TransportID))
-			// c.send_command(f"host:transport-id:{self._transport_id}")
+			cmd = "host:transport-id:" + fmt.Sprintf("%d", mixin.TransportID)
 		} else if mixin.Serial != "" {
 			// # host:tport:serial:xxx is also fine, but receive 12 bytes
 			// # recv: 4f 4b 41 59 14 00 00 00 00 00 00 00              OKAY........
 			// # so here use host:transport
-			c.SendCommand("host:transport:" + mixin.Serial)
-			// c.send_command(f"host:transport:{self._serial}")
-		} else {
-			log.Println("RuntimeError")
+			cmd = "host:transport:" + mixin.Serial
+		}
+	}
+	if cmd == "" {
+		return nil, fmt.Errorf("%w: serial or transport id required", ErrDeviceNotFound)
+	}
+	c, err := mixin.Client.connectContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if timeOut > 0 {
+		// 这里修改了一下 使用c设置Conn的timeout
+		err := c.SetTimeout(timeOut)
+		if err != nil {
+			c.Close()
+			return nil, err
 		}
-		c.CheckOkay()
+	}
+	if err = c.SendCommand(cmd); err == nil {
+		err = c.CheckOkay()
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
 	}
 	return c, nil
 }
@@ -232,38 +234,64 @@ type AdbDevice struct {
 	ShellMixin
 }
 
-func (adbDevice AdbDevice) getWithCommand(cmd string) string {
-	c, err := adbDevice.openTransport("", adbDevice.Client.SocketTime)
+func (adbDevice AdbDevice) getWithCommand(ctx context.Context, cmd string) (string, error) {
+	c, err := adbDevice.openTransport(ctx, cmd, adbDevice.Client.SocketTime)
 	if err != nil {
-		return err.Error()
+		return "", err
 	}
-	c.SendCommand(strings.Join([]string{"host-serial", adbDevice.Serial, cmd}, ":"))
-	c.CheckOkay()
+	defer c.Close()
 	return c.ReadStringBlock()
 }
 
-func (adbDevice AdbDevice) GetState() string {
-	return adbDevice.getWithCommand("get-state")
+func (adbDevice AdbDevice) GetState() (string, error) {
+	return adbDevice.GetStateContext(context.Background())
+}
+
+func (adbDevice AdbDevice) GetStateContext(ctx context.Context) (string, error) {
+	return adbDevice.getWithCommand(ctx, "get-state")
+}
+
+func (adbDevice AdbDevice) GetSerialNo() (string, error) {
+	return adbDevice.GetSerialNoContext(context.Background())
+}
+
+func (adbDevice AdbDevice) GetSerialNoContext(ctx context.Context) (string, error) {
+	return adbDevice.getWithCommand(ctx, "get-serialno")
+}
+
+func (adbDevice AdbDevice) GetDevPath() (string, error) {
+	return adbDevice.GetDevPathContext(context.Background())
+}
+
+func (adbDevice AdbDevice) GetDevPathContext(ctx context.Context) (string, error) {
+	return adbDevice.getWithCommand(ctx, "get-devpath")
 }
 
-func (adbDevice AdbDevice) GetSerialNo() string {
-	return adbDevice.getWithCommand("get-serialno")
+func (adbDevice AdbDevice) GetFeatures() (string, error) {
+	return adbDevice.GetFeaturesContext(context.Background())
 }
 
-func (adbDevice AdbDevice) GetDevPath() string {
-	return adbDevice.getWithCommand("get-devpath")
+func (adbDevice AdbDevice) GetFeaturesContext(ctx context.Context) (string, error) {
+	return adbDevice.getWithCommand(ctx, "features")
 }
 
-func (adbDevice AdbDevice) GetFeatures() string {
-	return adbDevice.getWithCommand("features")
+func (adbDevice AdbDevice) Info() (map[string]string, error) {
+	return adbDevice.InfoContext(context.Background())
 }
 
-func (adbDevice AdbDevice) Info() map[string]string {
+func (adbDevice AdbDevice) InfoContext(ctx context.Context) (map[string]string, error) {
+	var err error
 	res := map[string]string{}
-	res["serialno"] = adbDevice.GetSerialNo()
-	res["devpath"] = adbDevice.GetDevPath()
-	res["state"] = adbDevice.GetState()
-	return res
+	if res["serialno"], err = adbDevice.GetSerialNoContext(ctx); err != nil {
+		return nil, err
+	}
+	if res["devpath"], err = adbDevice.GetDevPathContext(ctx); err != nil {
+		return nil, err
+	}
+	if res["state"], err = adbDevice.GetStateContext(ctx); err != nil {
+		return nil, err
+	}
+	return res, nil
 }
 
 func (adbDevice AdbDevice) String() {
@@ -271,15 +299,23 @@ func (adbDevice AdbDevice) String() {
 }
 
 func (adbDevice AdbDevice) Sync() Sync {
-	return Sync{AdbClient: adbDevice.Client, Serial: adbDevice.Serial}
+	return Sync{AdbClient: adbDevice.Client, Serial: adbDevice.Serial, features: &syncFeatures{}}
 }
 
-func (adbDevice AdbDevice) AdbOut(command string) string {
+func (adbDevice AdbDevice) AdbOut(command string) (string, error) {
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	commandWithPrefix := "-s " + adbDevice.Serial + " " + command
 	cmd := exec.CommandContext(ctx, AdbPath(), strings.Split(commandWithPrefix, " ")...)
 	stdErr, err := cmd.StderrPipe()
+	if err != nil {
+		cancelFunc()
+		return "", err
+	}
 	stdOut, err := cmd.StdoutPipe()
+	if err != nil {
+		cancelFunc()
+		return "", err
+	}
 
 	defer func() {
 		cancelFunc()
@@ -287,105 +323,284 @@ func (adbDevice AdbDevice) AdbOut(command string) string {
 		_ = stdOut.Close()
 		_ = cmd.Wait()
 	}()
-	if err != nil {
-		log.Println(err.Error())
-		return ""
-	}
 	err = cmd.Start()
 
 	if err != nil {
-		log.Println(err.Error())
-		return ""
+		return "", err
 	}
 	bytesOut, err := ioutil.ReadAll(stdOut)
 	if err != nil {
-		log.Println(err.Error())
-		return ""
+		return "", err
 	}
 	bytesErr, err := ioutil.ReadAll(stdErr)
 	if err != nil {
-		log.Println(err.Error())
-		return ""
+		return "", err
 	}
 	if len(bytesErr) != 0 {
-		log.Println(string(bytesErr))
+		return strings.TrimSpace(string(bytesOut)), &AdbError{Service: command, Message: strings.TrimSpace(string(bytesErr))}
 	}
-	return strings.TrimSpace(string(bytesOut))
+	return strings.TrimSpace(string(bytesOut)), nil
 }
 
-func (adbDevice AdbDevice) Shell(cmdargs string, stream bool, timeOut time.Duration) interface{} {
-	c, err := adbDevice.openTransport("", timeOut)
+func (adbDevice AdbDevice) Shell(cmdargs string, timeOut time.Duration) (string, error) {
+	return adbDevice.shell(context.Background(), cmdargs, timeOut)
+}
+
+// ShellContext
+//
+//	@Description: 同 Shell, ctx 取消时关闭连接
+//	@receiver adbDevice
+//	@param ctx
+//	@param cmdargs
+//	@return string
+//	@return error
+func (adbDevice AdbDevice) ShellContext(ctx context.Context, cmdargs string) (string, error) {
+	return adbDevice.shell(ctx, cmdargs, 0)
+}
+
+func (adbDevice AdbDevice) shell(ctx context.Context, cmdargs string, timeOut time.Duration) (string, error) {
+	c, err := adbDevice.shellStream(ctx, cmdargs, timeOut)
 	if err != nil {
-		return err
+		return "", err
 	}
-	c.SendCommand("shell:" + cmdargs)
-	c.CheckOkay()
-	if stream {
-		return c
-	}
-	output := c.ReadUntilClose()
+	defer c.Close()
 	// 简单返回
-	return output
+	return c.ReadUntilClose()
+}
+
+// ShellStream
+//
+//	@Description: 执行shell指令并返回连接, 由调用方读取输出并关闭
+//	@receiver adbDevice
+//	@param cmdargs
+//	@return *AdbConnection
+//	@return error
+func (adbDevice AdbDevice) ShellStream(cmdargs string) (*AdbConnection, error) {
+	return adbDevice.shellStream(context.Background(), cmdargs, 0)
+}
+
+// ShellStreamContext
+//
+//	@Description: 同 ShellStream, 连接在 ctx 结束前一直有效
+//	@receiver adbDevice
+//	@param ctx
+//	@param cmdargs
+//	@return *AdbConnection
+//	@return error
+func (adbDevice AdbDevice) ShellStreamContext(ctx context.Context, cmdargs string) (*AdbConnection, error) {
+	return adbDevice.shellStream(ctx, cmdargs, 0)
+}
+
+func (adbDevice AdbDevice) shellStream(ctx context.Context, cmdargs string, timeOut time.Duration) (*AdbConnection, error) {
+	c, err := adbDevice.openTransport(ctx, "", timeOut)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.SendCommand("shell:" + cmdargs); err == nil {
+		err = c.CheckOkay()
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// execStream 同 shellStream, 使用 exec: 服务, 输出不经过 pty 转换, 适合读取二进制内容
+func (adbDevice AdbDevice) execStream(ctx context.Context, cmdargs string) (*AdbConnection, error) {
+	return adbDevice.openService(ctx, "exec:"+cmdargs)
+}
+
+// openService 切换到设备后打开任意设备服务, 如 exec:、abb_exec:
+func (adbDevice AdbDevice) openService(ctx context.Context, service string) (*AdbConnection, error) {
+	c, err := adbDevice.openTransport(ctx, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.SendCommand(service); err == nil {
+		err = c.CheckOkay()
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (adbDevice AdbDevice) ShellOutPut(cmd string) (string, error) {
+	return adbDevice.Client.Shell(adbDevice.Serial, cmd)
+}
+
+// ForWard
+//
+//	@Description: 将电脑上的 local 地址转发到设备上的 remote 地址, local 为 tcp:0 时请使用 ForwardPort 获取分配的端口
+//	@receiver adbDevice
+//	@param local 电脑端地址, 如 tcp:9000
+//	@param remote 设备端地址, 如 tcp:8000、localabstract:scrcpy
+//	@param noRebind local 已存在转发时返回错误而不是覆盖
+//	@return error
+func (adbDevice AdbDevice) ForWard(local, remote string, noRebind bool) error {
+	return adbDevice.ForWardContext(context.Background(), local, remote, noRebind)
 }
 
-func (adbDevice AdbDevice) ShellOutPut(cmd string) string {
-	res := adbDevice.Client.Shell(adbDevice.Serial, cmd, false)
-	return res.(string)
+func (adbDevice AdbDevice) ForWardContext(ctx context.Context, local, remote string, noRebind bool) error {
+	_, err := adbDevice.forward(ctx, local, remote, noRebind)
+	return err
 }
 
-func (adbDevice AdbDevice) ForWard(local, remote string, noRebind bool) (*AdbConnection, error) {
+// forward
+//
+//	@Description: 执行 forward 命令, local 为 tcp:0 时 adb server 会在执行结果后返回分配的端口
+//	@receiver adbDevice
+//	@param ctx
+//	@param local
+//	@param remote
+//	@param noRebind
+//	@return int 分配的端口, local 不是 tcp:0 时为 0
+//	@return error
+func (adbDevice AdbDevice) forward(ctx context.Context, local, remote string, noRebind bool) (int, error) {
 	args := []string{"forward"}
 	if noRebind {
 		args = append(args, "norebind")
 	}
-	args = append(args, []string{local, ";", remote}...)
-	return adbDevice.openTransport(strings.Join(args, ":"), adbDevice.Client.SocketTime)
+	args = append(args, local+";"+remote)
+	c, err := adbDevice.openTransport(ctx, strings.Join(args, ":"), adbDevice.Client.SocketTime)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+	// host 端第一个 OKAY 表示连接成功, 第二个才是执行结果
+	if err = c.CheckOkay(); err != nil {
+		return 0, err
+	}
+	if local != "tcp:0" {
+		return 0, nil
+	}
+	port, err := c.ReadStringBlock()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(port)
 }
 
-func (adbDevice AdbDevice) ForWardPort(remote interface{}) int {
-	tmpRemote := ""
-	switch remote.(type) {
+// ForwardPort
+//
+//	@Description: 返回转发到设备上 remote 的本地 tcp 端口, 已有转发时直接复用, 否则由 adb server 分配一个空闲端口
+//	@receiver adbDevice
+//	@param remote 设备端地址, int 类型表示 tcp 端口, 如 8000 或 "localabstract:scrcpy"
+//	@return int
+//	@return error
+func (adbDevice AdbDevice) ForwardPort(remote interface{}) (int, error) {
+	return adbDevice.ForwardPortContext(context.Background(), remote)
+}
+
+func (adbDevice AdbDevice) ForwardPortContext(ctx context.Context, remote interface{}) (int, error) {
+	var tmpRemote string
+	switch r := remote.(type) {
 	case int:
-		tmpRemote = "tcp:" + remote.(string)
+		tmpRemote = fmt.Sprintf("tcp:%d", r)
+	case string:
+		tmpRemote = r
 	default:
-		for _, f := range adbDevice.ForwardList() {
-			if f.Serial == adbDevice.Serial && f.Remote == tmpRemote && strings.HasPrefix(f.Local, "tcp") {
-				port, err := strconv.Atoi(f.Local[:2])
-				if err != nil {
-					return 0
-				}
-				return port
+		return 0, fmt.Errorf("unsupported remote type %T", remote)
+	}
+	items, err := adbDevice.ForwardListContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, f := range items {
+		if f.Remote == tmpRemote && strings.HasPrefix(f.Local, "tcp:") {
+			if port, err := strconv.Atoi(strings.TrimPrefix(f.Local, "tcp:")); err == nil {
+				return port, nil
 			}
 		}
 	}
-	localPort := GetFreePort()
-	adbDevice.ForWard(fmt.Sprintf("tcp:%d", localPort), tmpRemote, false)
-	return localPort
+	return adbDevice.forward(ctx, "tcp:0", tmpRemote, false)
 }
 
-func (adbDevice AdbDevice) ForwardList() []ForwardItem {
-	forwardItems := []ForwardItem{}
-	c, err := adbDevice.openTransport("list-forward", adbDevice.Client.SocketTime)
+// ForWardPort
+//
+//	Deprecated: 使用 ForwardPort
+func (adbDevice AdbDevice) ForWardPort(remote interface{}) (int, error) {
+	return adbDevice.ForwardPort(remote)
+}
+
+// ForwardList
+//
+//	@Description: 列出该设备的转发, adb server 返回所有设备的转发, 这里只保留当前设备的
+//	@receiver adbDevice
+//	@return []ForwardItem
+//	@return error
+func (adbDevice AdbDevice) ForwardList() ([]ForwardItem, error) {
+	return adbDevice.ForwardListContext(context.Background())
+}
+
+func (adbDevice AdbDevice) ForwardListContext(ctx context.Context) ([]ForwardItem, error) {
+	c, err := adbDevice.openTransport(ctx, "list-forward", adbDevice.Client.SocketTime)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	content, err := c.ReadStringBlock()
 	if err != nil {
-		return forwardItems
+		return nil, err
 	}
-	content := c.ReadStringBlock()
+	forwardItems := []ForwardItem{}
 	for _, line := range strings.Split(content, "\n") {
-		parts := strings.TrimSpace(line)
+		// 每行格式为: <serial> <local> <remote>
+		parts := strings.Fields(line)
 		if len(parts) != 3 {
 			continue
-		} else {
-			forwardItems = append(forwardItems, ForwardItem{
-				Serial: string(parts[0]),
-				Local:  string(parts[1]),
-				Remote: string(parts[2]),
-			})
 		}
+		if adbDevice.Serial != "" && parts[0] != adbDevice.Serial {
+			continue
+		}
+		forwardItems = append(forwardItems, ForwardItem{
+			Serial: parts[0],
+			Local:  parts[1],
+			Remote: parts[2],
+		})
+	}
+	return forwardItems, nil
+}
+
+// ForwardRemove
+//
+//	@Description: 删除本地地址为 local 的转发
+//	@receiver adbDevice
+//	@param local 如 tcp:9000
+//	@return error
+func (adbDevice AdbDevice) ForwardRemove(local string) error {
+	return adbDevice.ForwardRemoveContext(context.Background(), local)
+}
+
+func (adbDevice AdbDevice) ForwardRemoveContext(ctx context.Context, local string) error {
+	return adbDevice.runHostCommand(ctx, "killforward:"+local)
+}
+
+// ForwardRemoveAll 删除 adb server 上所有的转发, 与 adb forward --remove-all 一致, 包括其他设备的转发
+func (adbDevice AdbDevice) ForwardRemoveAll() error {
+	return adbDevice.ForwardRemoveAllContext(context.Background())
+}
+
+func (adbDevice AdbDevice) ForwardRemoveAllContext(ctx context.Context) error {
+	return adbDevice.runHostCommand(ctx, "killforward-all")
+}
+
+// runHostCommand 执行不返回内容的设备 host 命令, 第二个 OKAY 是执行结果
+func (adbDevice AdbDevice) runHostCommand(ctx context.Context, command string) error {
+	c, err := adbDevice.openTransport(ctx, command, adbDevice.Client.SocketTime)
+	if err != nil {
+		return err
 	}
-	return forwardItems
+	defer c.Close()
+	return c.CheckOkay()
 }
 
-func (adbDevice AdbDevice) Push(local, remote string) string {
+// Push
+//
+//	Deprecated: 依赖本机的 adb 程序, 请使用 Sync().Push 或 Sync().PushDir
+func (adbDevice AdbDevice) Push(local, remote string) (string, error) {
 	return adbDevice.AdbOut(fmt.Sprintf("push %v %v", local, remote))
 }
 
@@ -398,24 +613,40 @@ func (adbDevice AdbDevice) Push(local, remote string) string {
 //	@return net.Conn
 //	@return error
 func (adbDevice AdbDevice) CreateConnection(netWork, address string) (net.Conn, error) {
-	c, err := adbDevice.openTransport("", 0)
-	if err != nil {
-		return nil, err
-	}
-	c.SendCommand("host:transport:" + adbDevice.Serial)
-	c.CheckOkay()
+	return adbDevice.CreateConnectionContext(context.Background(), netWork, address)
+}
+
+// CreateConnectionContext
+//
+//	@Description: 同 CreateConnection, ctx 结束时返回的连接会被关闭
+//	@receiver adbDevice
+//	@param ctx
+//	@param netWork
+//	@param address
+//	@return net.Conn
+//	@return error
+func (adbDevice AdbDevice) CreateConnectionContext(ctx context.Context, netWork, address string) (net.Conn, error) {
+	var service string
 	switch netWork {
 	case TCP:
-		c.SendCommand("tcp:" + address)
-		c.CheckOkay()
+		service = "tcp:" + address
 	case UNIX, LOCALABSTRACT:
-		c.SendCommand("localabstract:" + address)
-		c.CheckOkay()
+		service = "localabstract:" + address
 	case LOCALFILESYSTEM, LOCAL, DEV, LOCALRESERVED:
-		c.SendCommand(netWork + ":" + address)
-		c.CheckOkay()
+		service = netWork + ":" + address
 	default:
-		panic("not support net work: " + netWork)
+		return nil, fmt.Errorf("not support net work: %s", netWork)
+	}
+	c, err := adbDevice.openTransport(ctx, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.SendCommand(service); err == nil {
+		err = c.CheckOkay()
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
 	}
 	return c.Conn, nil
 }
@@ -425,23 +656,40 @@ func (adbDevice AdbDevice) CreateConnection(netWork, address string) (net.Conn,
 type Sync struct {
 	*AdbClient
 	Serial string
+	// 传输文件时的压缩算法, 零值为自动选择, 设置为 CompressionNone 可关闭压缩
+	Compression Compression
+	// 传输进度回调, 用于 Push、Pull、PushDir、PullDir 等, 为 nil 时不统计
+	OnProgress ProgressFunc
+	// 设备的 feature, 用于选择 sync v1/v2 协议, 通过 AdbDevice.Sync 创建时会缓存
+	features *syncFeatures
 }
 
 // prepareSync
 //
 //	@Description: 准备同步 会创建一个tcp链接到服务端 执行些指令  在功能外部进行关闭链接
 //	@receiver sync
+//	@param ctx
 //	@param path
 //	@param cmd
 //	@return *AdbConnection
 //	@return error
-func (sync Sync) prepareSync(path, cmd string) (*AdbConnection, error) {
-	c, err := sync.AdbClient.Device(SerialNTransportID{Serial: sync.Serial}).openTransport("", 10)
+func (sync Sync) prepareSync(ctx context.Context, path, cmd string) (*AdbConnection, error) {
+	c, err := sync.AdbClient.Device(SerialNTransportID{Serial: sync.Serial}).openTransport(ctx, "", sync.SocketTime)
 	if err != nil {
 		return nil, err
 	}
-	c.SendCommand("sync:")
-	c.CheckOkay()
+	if err = c.SendCommand("sync:"); err == nil {
+		err = c.CheckOkay()
+	}
+	// SocketTime 只限制建立连接和握手, 传输大文件或流式读写的时间不受限制, 由 ctx 控制
+	if err == nil {
+		err = c.Conn.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.service = cmd
 	pathBy := []byte(path)
 	z := make([]byte, 4)
 	binary.LittleEndian.PutUint32(z, uint32(len(pathBy)))
@@ -449,67 +697,152 @@ func (sync Sync) prepareSync(path, cmd string) (*AdbConnection, error) {
 	msg = append(msg, pathBy...)
 	_, err = c.Conn.Write(msg)
 	if err != nil {
+		c.Close()
 		return nil, err
 	}
 	return c, nil
 }
 
-func (sync Sync) Exist(path string) bool {
-	stat, err := sync.Stat(path)
+// readSyncFail
+//
+//	@Description: 读取 sync 协议 FAIL 之后的错误信息, sync 中长度为4字节小端而非十六进制字符串
+//	@receiver sync
+//	@param c
+//	@return error
+func (sync Sync) readSyncFail(c *AdbConnection) error {
+	bs, err := c.Read(4)
+	if err != nil {
+		return err
+	}
+	msg, err := c.Read(int(binary.LittleEndian.Uint32(bs)))
+	if err != nil {
+		return err
+	}
+	return &AdbError{Service: c.service, Message: string(msg)}
+}
+
+func (sync Sync) readUint32s(c *AdbConnection, n int) ([]uint32, error) {
+	bs, err := c.Read(4 * n)
 	if err != nil {
-		return false
+		return nil, err
 	}
-	return stat.Mtime != nil
+	res := make([]uint32, n)
+	for i := range res {
+		res[i] = binary.LittleEndian.Uint32(bs[i*4:])
+	}
+	return res, nil
+}
+
+func (sync Sync) Exist(path string) (bool, error) {
+	return sync.ExistContext(context.Background(), path)
+}
+
+func (sync Sync) ExistContext(ctx context.Context, path string) (bool, error) {
+	stat, err := sync.StatContext(ctx, path)
+	if err != nil {
+		return false, err
+	}
+	return stat.Mtime != nil, nil
 }
 
 func (sync Sync) Stat(path string) (*FileInfo, error) {
+	return sync.StatContext(context.Background(), path)
+}
+
+// StatContext
+//
+//	@Description: 获取文件信息, 设备支持 stat_v2 时使用 STA2, 大小和时间为 64 位并包含 uid/gid 等信息;
+//	文件不存在时返回 Mtime 为 nil 的 FileInfo
+//	@receiver sync
+//	@param ctx
+//	@param path
+//	@return *FileInfo
+//	@return error
+func (sync Sync) StatContext(ctx context.Context, path string) (*FileInfo, error) {
+	v2, err := sync.supports(ctx, FeatureStatV2)
+	if err != nil {
+		return nil, err
+	}
+	if v2 {
+		return sync.statV2(ctx, path, STA2)
+	}
+	return sync.statV1(ctx, path)
+}
+
+// statV1 旧版 STAT, mode/size/mtime 均为 32 位
+func (sync Sync) statV1(ctx context.Context, path string) (*FileInfo, error) {
 	fileInfo := FileInfo{Path: path}
-	c, err := sync.prepareSync(path, "STAT")
+	c, err := sync.prepareSync(ctx, path, "STAT")
 	if err != nil {
-		return &fileInfo, err
+		return nil, err
 	}
 	defer c.Close()
-	if c.ReadString(4) == "stat" || err != nil {
-		log.Println("Stat sync error!")
+	id, err := c.ReadString(4)
+	if err != nil {
+		return nil, err
 	}
-	res := []uint32{}
-	for i := 0; i < 3; i++ {
-		res = append(res, binary.LittleEndian.Uint32(c.Read(4)))
+	if id != "STAT" {
+		return nil, fmt.Errorf("adb: unexpected sync response %q for STAT", id)
 	}
-	fileInfo.Mode = int(res[0])
-	fileInfo.Size = int(res[1])
-	if res[2] != 0 {
-		mtime := time.Unix(int64(res[2]), 0)
-		fileInfo.Mtime = &mtime
+	res, err := sync.readUint32s(c, 3)
+	if err != nil {
+		return nil, err
 	}
+	fileInfo.Mode = int(res[0])
+	fileInfo.Size = int64(res[1])
+	fileInfo.Mtime = unixTime(int64(res[2]))
 	return &fileInfo, nil
 }
 
 func (sync Sync) IterDirectory(path string) (*[]FileInfo, error) {
-	c, err := sync.prepareSync(path, "LIST")
-	defer c.Close()
+	return sync.IterDirectoryContext(context.Background(), path)
+}
+
+func (sync Sync) IterDirectoryContext(ctx context.Context, path string) (*[]FileInfo, error) {
+	v2, err := sync.supports(ctx, FeatureLsV2)
 	if err != nil {
 		return nil, err
 	}
+	if v2 {
+		fileInfos, err := sync.listV2(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		return &fileInfos, nil
+	}
+	c, err := sync.prepareSync(ctx, path, "LIST")
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
 	fileInfos := []FileInfo{}
 	for {
-		response := c.ReadString(4)
+		response, err := c.ReadString(4)
+		if err != nil {
+			return nil, err
+		}
 		if response == DONE {
 			break
 		}
+		if response == FAIL {
+			return nil, sync.readSyncFail(c)
+		}
+		if response != DENT {
+			return nil, fmt.Errorf("adb: unexpected sync response %q for LIST", response)
+		}
 		fileInfo := FileInfo{}
-		res := []uint32{}
-		for i := 0; i < 4; i++ {
-			res = append(res, binary.LittleEndian.Uint32(c.Read(4)))
+		res, err := sync.readUint32s(c, 4)
+		if err != nil {
+			return nil, err
+		}
+		name, err := c.ReadString(int(res[3]))
+		if err != nil {
+			return nil, err
 		}
-		name := c.ReadString(int(res[3]))
 		fileInfo.Mode = int(res[0])
-		fileInfo.Size = int(res[1])
+		fileInfo.Size = int64(res[1])
 		fileInfo.Path = name
-		if res[2] != 0 {
-			mtime := time.Unix(int64(res[2]), 0)
-			fileInfo.Mtime = &mtime
-		}
+		fileInfo.Mtime = unixTime(int64(res[2]))
 		fileInfos = append(fileInfos, fileInfo)
 	}
 	return &fileInfos, nil
@@ -519,70 +852,94 @@ func (sync Sync) List(path string) (*[]FileInfo, error) {
 	return sync.IterDirectory(path)
 }
 
-func (sync Sync) Push(src, dst string, mode int, check bool) (int, error) {
-	path := dst + "," + strconv.Itoa(syscall.S_IFREG|mode)
-	c, err := sync.prepareSync(path, "SEND")
-	defer c.Close()
-	if err != nil {
-		log.Println("Sync Push err ! ", err.Error())
+func (sync Sync) ListContext(ctx context.Context, path string) (*[]FileInfo, error) {
+	return sync.IterDirectoryContext(ctx, path)
+}
+
+func (sync Sync) Push(src, dst string, mode int, check bool) (int64, error) {
+	return sync.PushContext(context.Background(), src, dst, mode, check)
+}
+
+// PushContext
+//
+//	@Description: 推送本地文件到设备, 设备支持 sendrecv_v2 时使用 SND2, 更多选项见 PushFile
+//	@receiver sync
+//	@param ctx
+//	@param src
+//	@param dst
+//	@param mode 文件权限, 如 0644
+//	@param check 推送后在设备上计算 sha256(没有 sha256sum 时为 md5)并与本地比较, 不一致时返回 *ChecksumError
+//	@return int64 推送的字节数
+//	@return error
+func (sync Sync) PushContext(ctx context.Context, src, dst string, mode int, check bool) (int64, error) {
+	opts := PushOptions{Mode: mode}
+	if check {
+		opts.Verify = ChecksumAuto
 	}
-	file, err := os.OpenFile(src, os.O_RDONLY, 0644)
-	defer file.Close()
+	return sync.PushFile(ctx, src, dst, opts)
+}
+
+// send
+//
+//	@Description: 通过 SEND/SND2 把 r 的内容写入设备文件, 按协商结果压缩
+//	@receiver sync
+//	@param ctx
+//	@param r
+//	@param dst
+//	@param mode 包含文件类型的 unix mode, 符号链接时 r 的内容为链接目标
+//	@param mtime 写入后文件的修改时间
+//	@return int64 读取 r 的字节数
+//	@return error
+func (sync Sync) send(ctx context.Context, r io.Reader, dst string, mode int, mtime time.Time) (int64, error) {
+	w, err := sync.create(ctx, dst, mode, mtime)
 	if err != nil {
 		return 0, err
 	}
-	totalSize := 0
+	tracker := sync.newProgress(dst, 0, readerSize(r))
+	if tracker != nil {
+		r = progressReader{r, tracker}
+	}
+	totalSize, err := io.CopyBuffer(w, r, make([]byte, syncMaxData))
+	if err != nil {
+		w.abort(err)
+		return totalSize, err
+	}
+	if err = w.Close(); err != nil {
+		return totalSize, err
+	}
+	tracker.finish()
+	return totalSize, nil
+}
+
+// iterContent
+//
+//	@Description: 读取远端文件内容(压缩时为解压后的内容), 分块回调, chunk 只在回调期间有效, 回调返回错误时终止读取
+//	@receiver sync
+//	@param ctx
+//	@param path
+//	@param fn
+//	@return error
+func (sync Sync) iterContent(ctx context.Context, path string, fn func(chunk []byte) error) error {
+	r, err := sync.Open(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	buf := make([]byte, syncMaxData)
 	for {
-		chunk := make([]byte, 4096)
-		n, err := file.Read(chunk)
-		if err != nil && err != io.EOF {
-			log.Println("when Push, read local file error! ", err.Error())
-			break
-		}
-		if err == io.EOF || n == 0 {
-			msg := []byte("DONE")
-			bs := make([]byte, 4)
-			binary.LittleEndian.PutUint32(bs, uint32(time.Now().Unix()))
-			msg = append(msg, bs...)
-			_, err = c.Conn.Write(msg)
-			if err != nil {
-				log.Println("Sync Push send done error! ", err.Error())
+		n, err := r.Read(buf)
+		if n > 0 {
+			if err := fn(buf[:n]); err != nil {
+				return err
 			}
-			break
 		}
-		msg := []byte("DATA")
-		bs := make([]byte, 4)
-		binary.LittleEndian.PutUint32(bs, uint32(n))
-		msg = append(msg, bs...)
-		_, err = c.Conn.Write(msg)
-		if err != nil {
-			log.Println("when push write content error! ", err.Error())
-			break
+		if err == io.EOF {
+			return nil
 		}
-		_, err = c.Conn.Write(chunk[:n])
 		if err != nil {
-			log.Println("when push write content error! ", err.Error())
-			break
+			return err
 		}
-		totalSize = totalSize + n
 	}
-	if check {
-		sc := 0
-		for {
-			stat, _ := sync.Stat(dst)
-			fileSize := stat.Size
-			if fileSize == totalSize {
-				break
-			} else {
-				if sc == fileSize {
-					log.Println(fmt.Sprintf("Push not complete, expect pushed %d, actually pushed %d", totalSize, fileSize))
-				}
-			}
-			sc = fileSize
-		}
-
-	}
-	return totalSize, nil
 }
 
 // IterContent
@@ -592,80 +949,92 @@ func (sync Sync) Push(src, dst string, mode int, check bool) (int, error) {
 //	@param path
 //	@param ch
 //	@return []byte
-func (sync Sync) IterContent(path string, ch chan []byte) []byte {
-	c, err := sync.prepareSync(path, "RECV")
-	defer c.Close()
-	if err != nil {
-		log.Println("IterContent error ", err.Error())
-	}
+//	@return error
+func (sync Sync) IterContent(path string, ch chan []byte) ([]byte, error) {
+	return sync.IterContentContext(context.Background(), path, ch)
+}
+
+func (sync Sync) IterContentContext(ctx context.Context, path string, ch chan []byte) ([]byte, error) {
 	chunks := make([]byte, 0)
-	ok := 0
-	for {
-		cmd := c.ReadString(4)
-		switch cmd {
-		case FAIL:
-			//strSize := binary.LittleEndian.Uint32(c.Read(4))
-			//errMsg := c.ReadString(int(strSize))
-			ok = 1
-			break
-		case DATA:
-			chunkSize := binary.LittleEndian.Uint32(c.Read(4))
-			chunk := c.Read(int(chunkSize))
-			if len(chunk) != int(chunkSize) {
-				return nil
-			}
-			if ch != nil { //如果有通道也给通道发送数据
-				ch <- chunk
-			} else {
-				chunks = append(chunks, chunk...)
+	err := sync.iterContent(ctx, path, func(chunk []byte) error {
+		if ch != nil { //如果有通道也给通道发送数据
+			select {
+			case ch <- append([]byte(nil), chunk...):
+			case <-ctx.Done():
+				return ctx.Err()
 			}
-		case DONE:
-			ok = 1
-			break
-		default:
-			ok = 1
-			break
-		}
-		if ok == 1 {
-			break
+		} else {
+			chunks = append(chunks, chunk...)
 		}
-	}
+		return nil
+	})
 	if ch != nil {
 		close(ch)
-		return nil
-	} else {
-		return chunks
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
 	}
+	return chunks, nil
 }
 
-func (sync Sync) ReadBytes(path string) []byte {
+func (sync Sync) ReadBytes(path string) ([]byte, error) {
 	return sync.IterContent(path, nil)
 }
 
-func (sync Sync) ReadText(path string) string {
-	return string(sync.ReadBytes(path))
+func (sync Sync) ReadBytesContext(ctx context.Context, path string) ([]byte, error) {
+	return sync.IterContentContext(ctx, path, nil)
 }
 
-func (sync Sync) Pull(src, dst string) (int, error) {
-	f, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC, 0644)
-	defer f.Close()
+func (sync Sync) ReadText(path string) (string, error) {
+	res, err := sync.ReadBytes(path)
+	return string(res), err
+}
+
+func (sync Sync) ReadTextContext(ctx context.Context, path string) (string, error) {
+	res, err := sync.ReadBytesContext(ctx, path)
+	return string(res), err
+}
+
+func (sync Sync) Pull(src, dst string) (int64, error) {
+	return sync.PullContext(context.Background(), src, dst)
+}
+
+func (sync Sync) PullContext(ctx context.Context, src, dst string) (int64, error) {
+	return sync.pull(ctx, src, dst, -1)
+}
+
+// pull
+//
+//	@Description: 拉取文件到本地, 覆盖已有的文件
+//	@receiver sync
+//	@param ctx
+//	@param src
+//	@param dst
+//	@param total 文件大小, 用于进度回调, 未知时为 -1, 设置了 OnProgress 时会先 stat 获取
+//	@return int64
+//	@return error
+func (sync Sync) pull(ctx context.Context, src, dst string, total int64) (int64, error) {
+	if sync.OnProgress != nil && total < 0 {
+		if info, err := sync.StatContext(ctx, src); err == nil && info.Mode != 0 {
+			total = info.Size
+		}
+	}
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
 	if err != nil {
 		return 0, err
 	}
-	ch := make(chan []byte)
-	go sync.IterContent(src, ch)
-	i := 0
-	for {
-		b, ok := <-ch
-		if !ok {
-			break
-		}
-		_, err = f.Write(b)
-		if err != nil {
-			close(ch)
-			return i, err
-		}
-		i += len(b)
+	defer f.Close()
+	tracker := sync.newProgress(src, 0, total)
+	var i int64
+	err = sync.iterContent(ctx, src, func(chunk []byte) error {
+		n, err := f.Write(chunk)
+		i += int64(n)
+		tracker.add(n)
+		return err
+	})
+	if err == nil {
+		tracker.finish()
 	}
-	return i, nil
+	return i, err
 }
@@ -0,0 +1,118 @@
+package adbutils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrDeviceNotFound    = errors.New("adb: device not found")
+	ErrDeviceOffline     = errors.New("adb: device offline")
+	ErrUnauthorized      = errors.New("adb: device unauthorized")
+	ErrServerUnreachable = errors.New("adb: server unreachable")
+	ErrNotSupported      = errors.New("adb: feature not supported by device")
+	ErrPackageNotFound   = errors.New("adb: package not found")
+	ErrNoCurrentApp      = errors.New("adb: no foreground app")
+	ErrProcessNotFound   = errors.New("adb: process not found")
+)
+
+// AdbError
+// @Description: adb服务端返回 FAIL 时的错误, Message 为 FAIL 后长度前缀的内容
+type AdbError struct {
+	Service string
+	Message string
+}
+
+func (e *AdbError) Error() string {
+	if e.Service == "" {
+		return "adb: " + e.Message
+	}
+	return fmt.Sprintf("adb: %s: %s", e.Service, e.Message)
+}
+
+// Is
+//
+//	@Description: 根据服务端的错误信息匹配对应的预定义错误, 供 errors.Is 使用
+//	@receiver e
+//	@param target
+//	@return bool
+func (e *AdbError) Is(target error) bool {
+	msg := strings.ToLower(e.Message)
+	switch target {
+	case ErrDeviceNotFound:
+		return strings.HasPrefix(msg, "device '") && strings.HasSuffix(msg, "' not found") ||
+			strings.HasPrefix(msg, "no devices") || strings.HasPrefix(msg, "no emulators")
+	case ErrDeviceOffline:
+		return strings.HasPrefix(msg, "device offline")
+	case ErrUnauthorized:
+		return strings.HasPrefix(msg, "device unauthorized")
+	}
+	return false
+}
+
+// ShellError
+// @Description: 成功时没有输出的命令(如 pm grant、appops set)输出了错误信息
+type ShellError struct {
+	Command string
+	Output  string
+}
+
+func (e *ShellError) Error() string {
+	return fmt.Sprintf("adb: %s: %s", e.Command, e.Output)
+}
+
+// pm install 失败时可以用 errors.Is 匹配的错误
+var (
+	ErrInstallAlreadyExists       = errors.New("adb: install failed: package already exists")
+	ErrInstallSignatureMismatch   = errors.New("adb: install failed: signatures do not match installed package")
+	ErrInstallVersionDowngrade    = errors.New("adb: install failed: version downgrade")
+	ErrInstallInsufficientStorage = errors.New("adb: install failed: insufficient storage")
+	ErrInstallNoMatchingAbis      = errors.New("adb: install failed: no matching abis")
+	ErrInstallOlderSdk            = errors.New("adb: install failed: device sdk is older than required")
+	ErrInstallTestOnly            = errors.New("adb: install failed: test only package")
+)
+
+// InstallError
+// @Description: pm install 返回的 Failure [INSTALL_FAILED_*: message], Code 为方括号中的错误码
+type InstallError struct {
+	Code    string // 如 INSTALL_FAILED_VERSION_DOWNGRADE, 输出不是 Failure 格式时为空
+	Message string
+}
+
+func (e *InstallError) Error() string {
+	if e.Code == "" {
+		return "adb: install failed: " + e.Message
+	}
+	if e.Message == "" {
+		return "adb: install failed: " + e.Code
+	}
+	return fmt.Sprintf("adb: install failed: %s: %s", e.Code, e.Message)
+}
+
+// Is
+//
+//	@Description: 根据错误码匹配 ErrInstall* 预定义错误, 供 errors.Is 使用
+//	@receiver e
+//	@param target
+//	@return bool
+func (e *InstallError) Is(target error) bool {
+	switch target {
+	case ErrInstallAlreadyExists:
+		return e.Code == "INSTALL_FAILED_ALREADY_EXISTS"
+	case ErrInstallSignatureMismatch:
+		return e.Code == "INSTALL_FAILED_UPDATE_INCOMPATIBLE" || e.Code == "INSTALL_PARSE_FAILED_INCONSISTENT_CERTIFICATES" ||
+			e.Code == "INSTALL_FAILED_SHARED_USER_INCOMPATIBLE"
+	case ErrInstallVersionDowngrade:
+		return e.Code == "INSTALL_FAILED_VERSION_DOWNGRADE"
+	case ErrInstallInsufficientStorage:
+		return e.Code == "INSTALL_FAILED_INSUFFICIENT_STORAGE"
+	case ErrInstallNoMatchingAbis:
+		return e.Code == "INSTALL_FAILED_NO_MATCHING_ABIS"
+	case ErrInstallOlderSdk:
+		return e.Code == "INSTALL_FAILED_OLDER_SDK"
+	case ErrInstallTestOnly:
+		return e.Code == "INSTALL_FAILED_TEST_ONLY"
+	}
+	return false
+}
@@ -6,6 +6,7 @@ Test localhost Only one Device
 package main
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -13,17 +14,33 @@ import (
 )
 
 func GetServerVersion() {
-	adb := adbutils.AdbClient{Host: "localhost", Port: 5037, SocketTime: 10}
-	version := adb.ServerVersion()
+	adb := adbutils.AdbClient{Host: "localhost", Port: 5037, SocketTime: 10 * time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	version, err := adb.ServerVersionContext(ctx)
+	if err != nil {
+		fmt.Println("get server version error:", err)
+		return
+	}
 	fmt.Printf("version: %d\n\n", version)
 }
 
 func Shell(arg string) {
 	adb := adbutils.NewAdb("localhost", 5037, 10)
-	for _, device := range adb.DeviceList() {
+	devices, err := adb.DeviceList()
+	if err != nil {
+		fmt.Println("list devices error:", err)
+		return
+	}
+	for _, device := range devices {
 		fmt.Printf("Now show device: %s, ls: \n", device.Serial)
 		fmt.Printf("Now show device: %s, ls: \n", device.Properties)
-		fmt.Println(device.Shell(arg, false, time.Duration(time.Second*1)))
+		output, err := device.Shell(arg, time.Second)
+		if err != nil {
+			fmt.Println("shell error:", err)
+			continue
+		}
+		fmt.Println(output)
 	}
 }
 
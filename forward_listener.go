@@ -0,0 +1,223 @@
+package adbutils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// forwardStatsHistory 保留的已关闭连接统计条数
+const forwardStatsHistory = 128
+
+// ForwardConnStats
+// @Description: ListenForward 中单个连接的统计
+type ForwardConnStats struct {
+	ID         int
+	ClientAddr string // 本地客户端地址
+	Start      time.Time
+	End        time.Time // 连接关闭的时间, 活动连接为零值
+	BytesSent  int64     // 本地客户端发往设备的字节数
+	BytesRecv  int64     // 设备发往本地客户端的字节数
+	Err        error     // 连接设备失败或传输出错, 正常关闭为 nil
+}
+
+// ForwardListener
+// @Description: 电脑端监听的转发, 每个连接单独打开一个设备端连接, 不修改 adb server 的 forward 列表
+type ForwardListener struct {
+	device   AdbDevice
+	network  string
+	address  string
+	listener net.Listener
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	mu      sync.Mutex
+	nextID  int
+	active  map[int]*forwardConn
+	history []ForwardConnStats
+}
+
+type forwardConn struct {
+	// 原子计数, 放在最前面保证 32 位平台上 8 字节对齐
+	sent   int64
+	recv   int64
+	id     int
+	local  net.Conn
+	client string
+	start  time.Time
+}
+
+func (c *forwardConn) stats() ForwardConnStats {
+	return ForwardConnStats{
+		ID:         c.id,
+		ClientAddr: c.client,
+		Start:      c.start,
+		BytesSent:  atomic.LoadInt64(&c.sent),
+		BytesRecv:  atomic.LoadInt64(&c.recv),
+	}
+}
+
+// ListenForward
+//
+//	@Description: 在电脑上监听 localAddr, 每个接受的连接都通过 CreateConnection 打开一个新的设备端连接并双向转发.
+//	与 ForWard 不同, 不会修改 adb server 的全局 forward 列表, 适合并行测试. ctx 结束或调用 Close 时关闭监听和所有连接
+//	@receiver adbDevice
+//	@param ctx
+//	@param localAddr 如 127.0.0.1:0, unix 地址使用 unix:/tmp/agent.sock
+//	@param network 设备端网络, 同 CreateConnection, 如 TCP、LOCALABSTRACT
+//	@param remoteAddr 设备端地址, 如 8080、scrcpy
+//	@return *ForwardListener
+//	@return error
+func (adbDevice AdbDevice) ListenForward(ctx context.Context, localAddr, network, remoteAddr string) (*ForwardListener, error) {
+	localNetwork := "tcp"
+	if strings.HasPrefix(localAddr, "unix:") {
+		localNetwork, localAddr = "unix", strings.TrimPrefix(localAddr, "unix:")
+	}
+	l, err := net.Listen(localNetwork, localAddr)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	fl := &ForwardListener{
+		device:   adbDevice,
+		network:  network,
+		address:  remoteAddr,
+		listener: l,
+		ctx:      ctx,
+		cancel:   cancel,
+		nextID:   1,
+		active:   map[int]*forwardConn{},
+	}
+	fl.wg.Add(2)
+	go func() {
+		defer fl.wg.Done()
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+	go fl.serve()
+	return fl, nil
+}
+
+// Addr 返回本地监听地址, 监听 127.0.0.1:0 时可以从这里获取实际端口
+func (fl *ForwardListener) Addr() net.Addr {
+	return fl.listener.Addr()
+}
+
+// Close
+//
+//	@Description: 停止监听, 关闭所有活动连接并等待转发协程退出
+//	@receiver fl
+//	@return error
+func (fl *ForwardListener) Close() error {
+	fl.cancel()
+	fl.mu.Lock()
+	for _, c := range fl.active {
+		_ = c.local.Close()
+	}
+	fl.mu.Unlock()
+	fl.wg.Wait()
+	return nil
+}
+
+// Stats
+//
+//	@Description: 返回连接统计, 先是最近关闭的连接(最多保留 128 条), 然后是活动连接, 均按 ID 排序
+//	@receiver fl
+//	@return []ForwardConnStats
+func (fl *ForwardListener) Stats() []ForwardConnStats {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	active := make([]ForwardConnStats, 0, len(fl.active))
+	for _, c := range fl.active {
+		active = append(active, c.stats())
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].ID < active[j].ID })
+	return append(append([]ForwardConnStats(nil), fl.history...), active...)
+}
+
+func (fl *ForwardListener) serve() {
+	defer fl.wg.Done()
+	for {
+		conn, err := fl.listener.Accept()
+		if err != nil {
+			return
+		}
+		fl.mu.Lock()
+		if fl.ctx.Err() != nil {
+			fl.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		c := &forwardConn{id: fl.nextID, local: conn, start: time.Now()}
+		if addr := conn.RemoteAddr(); addr != nil {
+			c.client = addr.String()
+		}
+		fl.nextID++
+		fl.active[c.id] = c
+		fl.mu.Unlock()
+		fl.wg.Add(1)
+		go fl.pipe(c)
+	}
+}
+
+// pipe 双向转发, 任意一端关闭后关闭另一端, 与 adb forward 的行为一致
+func (fl *ForwardListener) pipe(c *forwardConn) {
+	defer fl.wg.Done()
+	var pipeErr error
+	remote, err := fl.device.CreateConnectionContext(fl.ctx, fl.network, fl.address)
+	if err != nil {
+		pipeErr = err
+	} else {
+		errs := make(chan error, 2)
+		go func() {
+			_, err := io.Copy(remote, countingReader{c.local, &c.sent})
+			errs <- err
+		}()
+		go func() {
+			_, err := io.Copy(c.local, countingReader{remote, &c.recv})
+			errs <- err
+		}()
+		pipeErr = <-errs
+		_ = remote.Close()
+		_ = c.local.Close()
+		<-errs
+		if fl.ctx.Err() != nil || isClosedErr(pipeErr) {
+			pipeErr = nil
+		}
+	}
+	_ = c.local.Close()
+
+	stats := c.stats()
+	stats.End = time.Now()
+	stats.Err = pipeErr
+	fl.mu.Lock()
+	delete(fl.active, c.id)
+	fl.history = append(fl.history, stats)
+	if len(fl.history) > forwardStatsHistory {
+		fl.history = fl.history[len(fl.history)-forwardStatsHistory:]
+	}
+	fl.mu.Unlock()
+}
+
+// isClosedErr 一端关闭后另一端的读写错误属于正常关闭
+func isClosedErr(err error) bool {
+	return err == nil || errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed)
+}
+
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
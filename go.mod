@@ -1,3 +1,9 @@
 module github.com/youluo1230/adbutils
 
 go 1.17
+
+require (
+	github.com/andybalholm/brotli v1.0.5
+	github.com/klauspost/compress v1.15.15
+	github.com/pierrec/lz4/v4 v4.1.17
+)
@@ -0,0 +1,425 @@
+package adbutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// installTmpDir 推送 apk 的临时目录, shell 用户可写, 安装后删除
+const installTmpDir = "/data/local/tmp"
+
+// 流式安装相关的设备 feature
+const (
+	FeatureCmd     = "cmd"      // 支持 exec:cmd package, Android 7.0 起
+	FeatureAbbExec = "abb_exec" // 支持 abb_exec: 直接调用 binder 服务, Android 10 起
+)
+
+var installFailureRe = regexp.MustCompile(`Failure \[(\w+)(?::\s*(.*))?\]`)
+
+// InstallOptions
+// @Description: Install 的选项, 前几项对应 pm install 的参数
+type InstallOptions struct {
+	Replace   bool   // -r, 覆盖安装已有的应用
+	Downgrade bool   // -d, 允许降级
+	GrantAll  bool   // -g, 授予 manifest 中的所有运行时权限
+	Test      bool   // -t, 允许安装 testOnly 的应用
+	ABI       string // --abi, 如 arm64-v8a
+	User      string // --user, 如 0、current、all
+
+	// 签名与已安装的应用不一致时先卸载再重新安装, 会清除应用数据
+	UninstallOnSignatureMismatch bool
+	// 安装成功后启动应用
+	Launch bool
+	// 推送到设备后校验内容, 见 PushOptions.Verify
+	Verify Checksum
+}
+
+// args 返回对应的 pm install 参数
+func (opts InstallOptions) args() []string {
+	var args []string
+	if opts.Replace {
+		args = append(args, "-r")
+	}
+	if opts.Downgrade {
+		args = append(args, "-d")
+	}
+	if opts.GrantAll {
+		args = append(args, "-g")
+	}
+	if opts.Test {
+		args = append(args, "-t")
+	}
+	if opts.ABI != "" {
+		args = append(args, "--abi", opts.ABI)
+	}
+	if opts.User != "" {
+		args = append(args, "--user", opts.User)
+	}
+	return args
+}
+
+// shellJoin 拼接 shell 参数, 只给包含特殊字符的参数加引号
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = arg
+		if arg == "" || strings.IndexFunc(arg, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=:,@%+", r))
+		}) >= 0 {
+			quoted[i] = shellQuote(arg)
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Install
+//
+//	@Description: 安装本地 apk 或 http(s) 地址的 apk: 下载到本地临时文件, 通过 sync 推送到 /data/local/tmp,
+//	执行 pm install, 结束后删除临时文件. 安装失败时返回 *InstallError, 可以用 errors.Is 匹配 ErrInstall* 错误
+//	@receiver adbDevice
+//	@param ctx
+//	@param pathOrURL
+//	@param opts
+//	@return error
+func (adbDevice AdbDevice) Install(ctx context.Context, pathOrURL string, opts InstallOptions) error {
+	local := pathOrURL
+	if strings.HasPrefix(pathOrURL, "http://") || strings.HasPrefix(pathOrURL, "https://") {
+		var err error
+		if local, err = downloadApk(ctx, pathOrURL); err != nil {
+			return err
+		}
+		defer os.Remove(local)
+	}
+	var info *ApkInfo
+	if opts.Launch || opts.UninstallOnSignatureMismatch {
+		var err error
+		if info, err = ParseApk(local); err != nil {
+			return err
+		}
+	}
+
+	err := adbDevice.installFile(ctx, local, opts)
+	if errors.Is(err, ErrInstallSignatureMismatch) && opts.UninstallOnSignatureMismatch {
+		if err = adbDevice.uninstall(ctx, info.Package); err != nil {
+			return err
+		}
+		err = adbDevice.installFile(ctx, local, opts)
+	}
+	if err != nil {
+		return err
+	}
+	if opts.Launch {
+		return adbDevice.launch(ctx, info.Package)
+	}
+	return nil
+}
+
+// installFile 安装本地 apk, 需要校验时推送到设备后再安装
+func (adbDevice AdbDevice) installFile(ctx context.Context, local string, opts InstallOptions) error {
+	if opts.Verify != ChecksumNone {
+		return adbDevice.pushInstall(ctx, opts, func(remote string) error {
+			_, err := adbDevice.Sync().PushFile(ctx, local, remote, PushOptions{Mode: 0644, Verify: opts.Verify})
+			return err
+		})
+	}
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return adbDevice.InstallStream(ctx, f, st.Size(), opts)
+}
+
+// InstallStream
+//
+//	@Description: 从 r 读取 apk 直接安装, 不在设备上保存临时文件. 设备支持 abb_exec 时使用 abb_exec:package install -S,
+//	支持 cmd 时使用 exec:cmd package install -S, 都不支持(Android 7.0 以下)或 size 未知时推送到 /data/local/tmp 后 pm install.
+//	opts 中的 Launch、UninstallOnSignatureMismatch、Verify 只在 Install 中使用
+//	@receiver adbDevice
+//	@param ctx
+//	@param r
+//	@param size apk 的大小, 未知时为 -1
+//	@param opts
+//	@return error
+func (adbDevice AdbDevice) InstallStream(ctx context.Context, r io.Reader, size int64, opts InstallOptions) error {
+	features, err := adbDevice.GetFeaturesContext(ctx)
+	if err != nil {
+		return err
+	}
+	args := append([]string{"install", "-S", strconv.FormatInt(size, 10)}, opts.args()...)
+	var service string
+	switch {
+	case size < 0:
+	case hasFeature(features, FeatureAbbExec):
+		// abb_exec 的参数以 \0 分隔, 不经过 shell
+		service = "abb_exec:" + strings.Join(append([]string{"package"}, args...), "\x00")
+	case hasFeature(features, FeatureCmd):
+		service = "exec:cmd package " + shellJoin(args)
+	}
+	if service == "" {
+		return adbDevice.pushInstall(ctx, opts, func(remote string) error {
+			w, err := adbDevice.Sync().create(ctx, remote, syscall.S_IFREG|0644, time.Now())
+			if err != nil {
+				return err
+			}
+			if _, err = io.CopyBuffer(w, r, make([]byte, syncMaxData)); err != nil {
+				w.abort(err)
+				return err
+			}
+			return w.Close()
+		})
+	}
+	c, err := adbDevice.openService(ctx, service)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if _, err = io.CopyN(c.Conn, r, size); err != nil {
+		return err
+	}
+	output, err := c.ReadUntilClose()
+	if err != nil {
+		return err
+	}
+	return parseInstallOutput(output)
+}
+
+// pushInstall 通过 push 把 apk 写入临时文件后安装, 无论是否成功都删除设备上的临时文件
+func (adbDevice AdbDevice) pushInstall(ctx context.Context, opts InstallOptions, push func(remote string) error) error {
+	remote := fmt.Sprintf("%s/adbutils-%d.apk", installTmpDir, time.Now().UnixNano())
+	defer func() {
+		// ctx 可能已经结束, 清理时不使用 ctx
+		_, _ = adbDevice.runContext(context.Background(), "rm -f "+shellQuote(remote))
+	}()
+	if err := push(remote); err != nil {
+		return err
+	}
+	return adbDevice.pmInstall(ctx, remote, opts)
+}
+
+// pmInstall 安装设备上已有的 apk
+func (mixin ShellMixin) pmInstall(ctx context.Context, remote string, opts InstallOptions) error {
+	args := append([]string{"pm", "install"}, opts.args()...)
+	output, err := mixin.runContext(ctx, shellJoin(args)+" "+shellQuote(remote))
+	if err != nil {
+		return err
+	}
+	return parseInstallOutput(output)
+}
+
+// parseInstallOutput 输出包含 Success 时成功, 否则解析 Failure [CODE: message]
+func parseInstallOutput(output string) error {
+	if strings.Contains(output, "Success") {
+		return nil
+	}
+	if m := installFailureRe.FindStringSubmatch(output); m != nil {
+		return &InstallError{Code: m[1], Message: strings.TrimSpace(m[2])}
+	}
+	return &InstallError{Message: strings.TrimSpace(output)}
+}
+
+// uninstall 与 Uninstall 不同, 输出不是 Success 时返回错误
+func (mixin ShellMixin) uninstall(ctx context.Context, packageName string) error {
+	output, err := mixin.runContext(ctx, "pm uninstall "+shellQuote(packageName))
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(output, "Success") {
+		return &AdbError{Service: "pm uninstall", Message: strings.TrimSpace(output)}
+	}
+	return nil
+}
+
+// launch 通过 monkey 启动应用的 launcher activity
+func (mixin ShellMixin) launch(ctx context.Context, packageName string) error {
+	output, err := mixin.runContext(ctx, "monkey -p "+shellQuote(packageName)+" -c android.intent.category.LAUNCHER 1")
+	if err != nil {
+		return err
+	}
+	if strings.Contains(output, "No activities found") || strings.Contains(output, "monkey aborted") {
+		return &AdbError{Service: "monkey", Message: strings.TrimSpace(output)}
+	}
+	return nil
+}
+
+// downloadApk 下载到本地临时文件, 调用方负责删除
+func downloadApk(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("adb: download %s: %s", url, resp.Status)
+	}
+	f, err := os.CreateTemp("", "adbutils-*.apk")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+var installSessionRe = regexp.MustCompile(`\[(\d+)\]`)
+
+// InstallMultiple
+//
+//	@Description: 在一个安装会话中安装 base apk 和 split apk, 对应 adb install-multiple:
+//	pm install-create 创建会话, 逐个 install-write, 最后 install-commit, 任意一步失败时 install-abandon, 不会只安装一部分.
+//	设备支持 abb_exec 或 cmd 时 install-write 直接从本地文件流式写入, 否则先推送到 /data/local/tmp
+//	@receiver adbDevice
+//	@param ctx
+//	@param apks 本地 apk 路径
+//	@param opts
+//	@return error
+func (adbDevice AdbDevice) InstallMultiple(ctx context.Context, apks []string, opts InstallOptions) error {
+	if len(apks) == 0 {
+		return fmt.Errorf("adb: no apk to install")
+	}
+	var info *ApkInfo
+	if opts.Launch || opts.UninstallOnSignatureMismatch {
+		for _, apk := range apks {
+			i, err := ParseApk(apk)
+			if err != nil {
+				return err
+			}
+			if i.Split == "" {
+				info = i
+				break
+			}
+		}
+		if info == nil {
+			return fmt.Errorf("adb: base apk not found")
+		}
+	}
+	err := adbDevice.installSession(ctx, apks, opts)
+	if errors.Is(err, ErrInstallSignatureMismatch) && opts.UninstallOnSignatureMismatch {
+		if err = adbDevice.uninstall(ctx, info.Package); err != nil {
+			return err
+		}
+		err = adbDevice.installSession(ctx, apks, opts)
+	}
+	if err != nil {
+		return err
+	}
+	if opts.Launch {
+		return adbDevice.launch(ctx, info.Package)
+	}
+	return nil
+}
+
+// installSession 创建安装会话并写入所有 apk 后提交, 失败时放弃会话
+func (adbDevice AdbDevice) installSession(ctx context.Context, apks []string, opts InstallOptions) (err error) {
+	var total int64
+	sizes := make([]int64, len(apks))
+	for i, apk := range apks {
+		st, err := os.Stat(apk)
+		if err != nil {
+			return err
+		}
+		sizes[i] = st.Size()
+		total += st.Size()
+	}
+	features, err := adbDevice.GetFeaturesContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	args := append([]string{"pm", "install-create", "-S", strconv.FormatInt(total, 10)}, opts.args()...)
+	output, err := adbDevice.runContext(ctx, shellJoin(args))
+	if err != nil {
+		return err
+	}
+	m := installSessionRe.FindStringSubmatch(output)
+	if !strings.Contains(output, "Success") || m == nil {
+		return parseInstallOutput(output)
+	}
+	session := m[1]
+	var remotes []string
+	defer func() {
+		// ctx 可能已经结束, 清理时不使用 ctx
+		if err != nil {
+			_, _ = adbDevice.runContext(context.Background(), "pm install-abandon "+session)
+		}
+		if len(remotes) > 0 {
+			_, _ = adbDevice.runContext(context.Background(), "rm -f "+shellJoin(remotes))
+		}
+	}()
+
+	for i, apk := range apks {
+		// 会话中的名称不能重复, 与 adb 一样加上序号
+		name := fmt.Sprintf("%d_%s", i, filepath.Base(apk))
+		size := strconv.FormatInt(sizes[i], 10)
+		switch {
+		case hasFeature(features, FeatureAbbExec):
+			err = adbDevice.installWriteStream(ctx, "abb_exec:"+strings.Join([]string{"package", "install-write", "-S", size, session, name, "-"}, "\x00"), apk, sizes[i])
+		case hasFeature(features, FeatureCmd):
+			err = adbDevice.installWriteStream(ctx, "exec:cmd package "+shellJoin([]string{"install-write", "-S", size, session, name, "-"}), apk, sizes[i])
+		default:
+			remote := fmt.Sprintf("%s/adbutils-%d-%s", installTmpDir, time.Now().UnixNano(), name)
+			remotes = append(remotes, remote)
+			if _, err = adbDevice.Sync().PushFile(ctx, apk, remote, PushOptions{Mode: 0644, Verify: opts.Verify}); err != nil {
+				return err
+			}
+			output, err = adbDevice.runContext(ctx, shellJoin([]string{"pm", "install-write", "-S", size, session, name})+" "+shellQuote(remote))
+			if err == nil && !strings.Contains(output, "Success") {
+				err = parseInstallOutput(output)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if output, err = adbDevice.runContext(ctx, "pm install-commit "+session); err != nil {
+		return err
+	}
+	return parseInstallOutput(output)
+}
+
+// installWriteStream 通过 exec:/abb_exec: 的 install-write 把本地 apk 写入安装会话
+func (adbDevice AdbDevice) installWriteStream(ctx context.Context, service, apk string, size int64) error {
+	f, err := os.Open(apk)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	c, err := adbDevice.openService(ctx, service)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if _, err = io.CopyN(c.Conn, f, size); err != nil {
+		return err
+	}
+	output, err := c.ReadUntilClose()
+	if err != nil {
+		return err
+	}
+	return parseInstallOutput(output)
+}
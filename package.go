@@ -0,0 +1,326 @@
+package adbutils
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PermissionState
+// @Description: dumpsys package 中一项权限的授予状态
+type PermissionState struct {
+	Name    string
+	Granted bool
+	Flags   []string // 如 USER_SET、USER_FIXED, 只有运行时权限有
+}
+
+// PackageInfo
+// @Description: dumpsys package <包名> 中的应用信息
+type PackageInfo struct {
+	Name                 string
+	VersionName          string
+	VersionCode          int64
+	MinSdk               int // Android 7.0 以下的设备没有 minSdk, 为 0
+	TargetSdk            int
+	CodePath             string
+	Installer            string    // installerPackageName, adb 安装的为空
+	FirstInstallTime     time.Time // 设备时间, 按本地时区解析
+	LastUpdateTime       time.Time
+	Signatures           []string // 签名证书内容的 hashCode(十六进制), 相同签名在不同设备上一致
+	Enabled              bool     // 用户 0 的 enabled 状态为 0(默认) 或 1(已启用)
+	RequestedPermissions []string
+	InstallPermissions   []PermissionState // 安装时授予的权限
+	RuntimePermissions   []PermissionState // 用户 0 的运行时权限
+}
+
+// GrantedPermissions 返回已授予的安装权限和运行时权限
+func (info *PackageInfo) GrantedPermissions() []string {
+	var granted []string
+	for _, states := range [][]PermissionState{info.InstallPermissions, info.RuntimePermissions} {
+		for _, p := range states {
+			if p.Granted {
+				granted = append(granted, p.Name)
+			}
+		}
+	}
+	return granted
+}
+
+var (
+	packageHeaderRe = regexp.MustCompile(`^\s*Package \[([^\]]+)\]`)
+	// Android 9 以上为 PackageSignatures{9c4a2b1 version:2, signatures:[6d9e31ea], past signatures:[]},
+	// 以下为 PackageSignatures{42c0d8e8 [41a3f2d0]}
+	packageSignatureRe = regexp.MustCompile(`PackageSignatures\{\S+ (?:version:\d+, signatures:)?\[([0-9a-fA-F, ]*)\]`)
+	packageUserRe      = regexp.MustCompile(`^User (\d+):`)
+	packageEnabledRe   = regexp.MustCompile(`\benabled=(\d+)`)
+)
+
+const packageTimeLayout = "2006-01-02 15:04:05"
+
+// PackageInfo
+//
+//	@Description: 通过 dumpsys package 获取应用的版本、安装时间、签名和权限等信息, 应用未安装时返回 ErrPackageNotFound
+//	@receiver mixin
+//	@param ctx
+//	@param packageName
+//	@return *PackageInfo
+//	@return error
+func (mixin ShellMixin) PackageInfo(ctx context.Context, packageName string) (*PackageInfo, error) {
+	output, err := mixin.runContext(ctx, "dumpsys package "+shellQuote(packageName))
+	if err != nil {
+		return nil, err
+	}
+	info := parsePackageInfo(output, packageName)
+	if info == nil {
+		return nil, fmt.Errorf("%w: %s", ErrPackageNotFound, packageName)
+	}
+	return info, nil
+}
+
+// parsePackageInfo 只解析 Packages: 下第一个 Package [包名] 块, 之后的 Hidden system packages 是被更新前的系统应用
+func parsePackageInfo(output, packageName string) *PackageInfo {
+	var info *PackageInfo
+	var section string // 当前所在的权限列表
+	indent, sectionDepth, user := -1, -1, -1
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		trimmed := strings.TrimSpace(line)
+		depth := len(line) - len(strings.TrimLeft(line, " "))
+		if info == nil {
+			if m := packageHeaderRe.FindStringSubmatch(line); m != nil && m[1] == packageName {
+				info = &PackageInfo{Name: packageName}
+				indent = depth
+			}
+			continue
+		}
+		if trimmed == "" {
+			continue
+		}
+		if depth <= indent {
+			break
+		}
+
+		// 权限列表中的每一项比标题多缩进一级
+		if section != "" && depth > sectionDepth {
+			switch section {
+			case "requested permissions:":
+				// Android 10 以上受限权限带有 ": restricted=true" 后缀
+				info.RequestedPermissions = append(info.RequestedPermissions, strings.TrimSpace(strings.SplitN(trimmed, ":", 2)[0]))
+			case "install permissions:":
+				info.InstallPermissions = append(info.InstallPermissions, parsePermissionState(trimmed))
+			case "runtime permissions:":
+				if user == 0 {
+					info.RuntimePermissions = append(info.RuntimePermissions, parsePermissionState(trimmed))
+				}
+			}
+			continue
+		}
+		section = ""
+		switch trimmed {
+		case "requested permissions:", "install permissions:", "runtime permissions:":
+			section, sectionDepth = trimmed, depth
+			continue
+		}
+		if m := packageUserRe.FindStringSubmatch(trimmed); m != nil {
+			user, _ = strconv.Atoi(m[1])
+			if user == 0 {
+				if m := packageEnabledRe.FindStringSubmatch(trimmed); m != nil {
+					info.Enabled = m[1] == "0" || m[1] == "1"
+				}
+			}
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "signatures=") {
+			if m := packageSignatureRe.FindStringSubmatch(trimmed); m != nil {
+				for _, sig := range strings.Split(m[1], ",") {
+					if sig = strings.TrimSpace(sig); sig != "" {
+						info.Signatures = append(info.Signatures, sig)
+					}
+				}
+			}
+			continue
+		}
+		for _, field := range packageFields(trimmed) {
+			key, value := field[0], field[1]
+			switch key {
+			case "versionCode":
+				info.VersionCode, _ = strconv.ParseInt(value, 10, 64)
+			case "minSdk":
+				info.MinSdk, _ = strconv.Atoi(value)
+			case "targetSdk":
+				info.TargetSdk, _ = strconv.Atoi(value)
+			case "versionName":
+				info.VersionName = value
+			case "codePath":
+				info.CodePath = value
+			case "installerPackageName":
+				if value != "null" {
+					info.Installer = value
+				}
+			case "firstInstallTime":
+				info.FirstInstallTime, _ = time.ParseInLocation(packageTimeLayout, value, time.Local)
+			case "lastUpdateTime":
+				info.LastUpdateTime, _ = time.ParseInLocation(packageTimeLayout, value, time.Local)
+			}
+		}
+	}
+	return info
+}
+
+// packageFields 拆分 key=value 行, 如 "versionCode=1 minSdk=21 targetSdk=33",
+// versionName 和时间的值可能含空格, 这类字段独占一行, 取整行的值
+func packageFields(line string) [][2]string {
+	key, value, ok := cutString(line, "=")
+	if !ok {
+		return nil
+	}
+	switch key {
+	case "versionName", "firstInstallTime", "lastUpdateTime", "codePath", "installerPackageName":
+		return [][2]string{{key, value}}
+	}
+	var fields [][2]string
+	for _, f := range strings.Fields(line) {
+		if key, value, ok := cutString(f, "="); ok {
+			fields = append(fields, [2]string{key, value})
+		}
+	}
+	return fields
+}
+
+// parsePermissionState 解析 "android.permission.CAMERA: granted=false, flags=[ USER_SET|USER_FIXED ]"
+func parsePermissionState(line string) PermissionState {
+	name, rest, _ := cutString(line, ":")
+	state := PermissionState{Name: strings.TrimSpace(name)}
+	for _, attr := range strings.Split(rest, ", ") {
+		key, value, _ := cutString(strings.TrimSpace(attr), "=")
+		switch key {
+		case "granted":
+			state.Granted = value == "true"
+		case "flags":
+			value = strings.Trim(value, "[] ")
+			for _, flag := range strings.FieldsFunc(value, func(r rune) bool { return r == '|' || r == ' ' }) {
+				state.Flags = append(state.Flags, flag)
+			}
+		}
+	}
+	return state
+}
+
+// cutString 同 go1.18 的 strings.Cut
+func cutString(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// ListPackagesOptions
+// @Description: pm list packages 的选项
+type ListPackagesOptions struct {
+	System      bool   // -s 只列出系统应用
+	ThirdParty  bool   // -3 只列出第三方应用
+	Disabled    bool   // -d 只列出已停用的应用
+	Enabled     bool   // -e 只列出已启用的应用
+	Path        bool   // -f 同时输出 apk 路径
+	Installer   bool   // -i 同时输出安装来源
+	VersionCode bool   // --show-versioncode, Android 9.0 以上
+	UID         bool   // -U 同时输出 uid, Android 8.0 以上
+	User        string // --user, 如 "0"、"all"
+	Filter      string // 包名包含的字符串
+}
+
+func (opts ListPackagesOptions) args() []string {
+	var args []string
+	for _, flag := range []struct {
+		on   bool
+		name string
+	}{
+		{opts.System, "-s"}, {opts.ThirdParty, "-3"}, {opts.Disabled, "-d"}, {opts.Enabled, "-e"},
+		{opts.Path, "-f"}, {opts.Installer, "-i"}, {opts.VersionCode, "--show-versioncode"}, {opts.UID, "-U"},
+	} {
+		if flag.on {
+			args = append(args, flag.name)
+		}
+	}
+	if opts.User != "" {
+		args = append(args, "--user", opts.User)
+	}
+	if opts.Filter != "" {
+		args = append(args, opts.Filter)
+	}
+	return args
+}
+
+// PackageItem
+// @Description: pm list packages 的一行, 未指定对应选项的字段为空
+type PackageItem struct {
+	Name        string
+	Path        string // -f
+	Installer   string // -i, 没有安装来源时为空
+	VersionCode int64  // --show-versioncode
+	UID         int    // -U
+}
+
+func (mixin ShellMixin) ListPackages(opts ListPackagesOptions) ([]PackageItem, error) {
+	return mixin.ListPackagesContext(context.Background(), opts)
+}
+
+// ListPackagesContext
+//
+//	@Description: 按选项列出应用, 如 ListPackagesContext(ctx, ListPackagesOptions{ThirdParty: true, Path: true}),
+//	零值的选项列出所有应用
+//	@receiver mixin
+//	@param ctx
+//	@param opts
+//	@return []PackageItem
+//	@return error
+func (mixin ShellMixin) ListPackagesContext(ctx context.Context, opts ListPackagesOptions) ([]PackageItem, error) {
+	output, err := mixin.runContext(ctx, shellJoin(append([]string{"pm", "list", "packages"}, opts.args()...)))
+	if err != nil {
+		return nil, err
+	}
+	return parsePackageList(output, opts.Path), nil
+}
+
+// parsePackageList 解析 "package:/data/app/~~Hk3A==/com.foo-Zx9Q==/base.apk=com.foo versionCode:12  installer=null uid:10153",
+// 路径中可能有 =, 包名中没有
+func parsePackageList(output string, withPath bool) []PackageItem {
+	items := []PackageItem{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "package:") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "package:"))
+		if len(fields) == 0 {
+			continue
+		}
+		item := PackageItem{Name: fields[0]}
+		if i := strings.LastIndex(fields[0], "="); withPath && i >= 0 {
+			item.Path, item.Name = fields[0][:i], fields[0][i+1:]
+		}
+		for _, f := range fields[1:] {
+			switch {
+			case strings.HasPrefix(f, "versionCode:"):
+				item.VersionCode, _ = strconv.ParseInt(strings.TrimPrefix(f, "versionCode:"), 10, 64)
+			case strings.HasPrefix(f, "installer="):
+				if installer := strings.TrimPrefix(f, "installer="); installer != "null" {
+					item.Installer = installer
+				}
+			case strings.HasPrefix(f, "uid:"):
+				// 多用户时为 uid:10153,1010153, 取第一个
+				uid := strings.SplitN(strings.TrimPrefix(f, "uid:"), ",", 2)[0]
+				item.UID, _ = strconv.Atoi(uid)
+			}
+		}
+		items = append(items, item)
+	}
+	return items
+}
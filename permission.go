@@ -0,0 +1,160 @@
+package adbutils
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// AppOpMode appops 的模式
+type AppOpMode string
+
+const (
+	AppOpAllow      AppOpMode = "allow"
+	AppOpIgnore     AppOpMode = "ignore"
+	AppOpDeny       AppOpMode = "deny"
+	AppOpDefault    AppOpMode = "default"
+	AppOpForeground AppOpMode = "foreground" // Android 10 以上
+)
+
+// AppOp
+// @Description: appops get 的一项
+type AppOp struct {
+	Name string // 如 CAMERA、SYSTEM_ALERT_WINDOW
+	Mode AppOpMode
+	Uid  bool // Uid mode: 开头, 对同一 uid 的所有应用生效
+}
+
+// 如 "CAMERA: allow; time=+2m10s ago" 或 "Uid mode: LEGACY_STORAGE: allow"
+var appOpRe = regexp.MustCompile(`^(Uid mode: )?([A-Z0-9_]+): ([a-z]+)`)
+
+// runNoOutput 执行成功时没有输出的命令, 有输出时返回 *ShellError
+func (mixin ShellMixin) runNoOutput(ctx context.Context, args ...string) error {
+	cmd := shellJoin(args)
+	output, err := mixin.runContext(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	if output = strings.TrimSpace(output); output != "" {
+		return &ShellError{Command: cmd, Output: output}
+	}
+	return nil
+}
+
+// GrantPermission
+//
+//	@Description: pm grant 授予运行时权限, 应用未声明该权限或不是运行时权限时返回 *ShellError
+//	@receiver mixin
+//	@param ctx
+//	@param packageName
+//	@param permission 如 android.permission.CAMERA
+//	@return error
+func (mixin ShellMixin) GrantPermission(ctx context.Context, packageName, permission string) error {
+	return mixin.runNoOutput(ctx, "pm", "grant", packageName, permission)
+}
+
+// RevokePermission
+//
+//	@Description: pm revoke 撤销运行时权限, 应用正在运行时会被系统杀死
+//	@receiver mixin
+//	@param ctx
+//	@param packageName
+//	@param permission
+//	@return error
+func (mixin ShellMixin) RevokePermission(ctx context.Context, packageName, permission string) error {
+	return mixin.runNoOutput(ctx, "pm", "revoke", packageName, permission)
+}
+
+// ListPermissions
+//
+//	@Description: 返回应用在用户 0 下的运行时权限及授予状态, 只有运行时权限可以 grant/revoke
+//	@receiver mixin
+//	@param ctx
+//	@param packageName
+//	@return []PermissionState
+//	@return error
+func (mixin ShellMixin) ListPermissions(ctx context.Context, packageName string) ([]PermissionState, error) {
+	info, err := mixin.PackageInfo(ctx, packageName)
+	if err != nil {
+		return nil, err
+	}
+	return info.RuntimePermissions, nil
+}
+
+// ResetPermissions
+//
+//	@Description: 撤销应用已授予的运行时权限, 系统或策略固定的权限不能撤销, 会跳过;
+//	packageName 为空时执行 pm reset-permissions, 重置所有应用
+//	@receiver mixin
+//	@param ctx
+//	@param packageName
+//	@return error
+func (mixin ShellMixin) ResetPermissions(ctx context.Context, packageName string) error {
+	if packageName == "" {
+		return mixin.runNoOutput(ctx, "pm", "reset-permissions")
+	}
+	permissions, err := mixin.ListPermissions(ctx, packageName)
+	if err != nil {
+		return err
+	}
+	for _, p := range permissions {
+		if !p.Granted || p.hasFlag("SYSTEM_FIXED") || p.hasFlag("POLICY_FIXED") {
+			continue
+		}
+		if err := mixin.RevokePermission(ctx, packageName, p.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p PermissionState) hasFlag(flag string) bool {
+	for _, f := range p.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// SetAppOp
+//
+//	@Description: appops set 修改应用的操作权限, 如 SetAppOp(ctx, pkg, "SYSTEM_ALERT_WINDOW", AppOpAllow)
+//	@receiver mixin
+//	@param ctx
+//	@param packageName
+//	@param op
+//	@param mode
+//	@return error
+func (mixin ShellMixin) SetAppOp(ctx context.Context, packageName, op string, mode AppOpMode) error {
+	return mixin.runNoOutput(ctx, "appops", "set", packageName, op, string(mode))
+}
+
+// GetAppOps
+//
+//	@Description: appops get 返回应用被修改过或使用过的操作权限
+//	@receiver mixin
+//	@param ctx
+//	@param packageName
+//	@return []AppOp
+//	@return error
+func (mixin ShellMixin) GetAppOps(ctx context.Context, packageName string) ([]AppOp, error) {
+	cmd := shellJoin([]string{"appops", "get", packageName})
+	output, err := mixin.runContext(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	ops := []AppOp{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		// Android 11 以上每项下面有缩进的访问记录
+		if m := appOpRe.FindStringSubmatch(line); m != nil {
+			ops = append(ops, AppOp{Name: m[2], Mode: AppOpMode(m[3]), Uid: m[1] != ""})
+			continue
+		}
+		if strings.HasPrefix(line, "Error") || strings.HasPrefix(line, "Unknown") {
+			return nil, &ShellError{Command: cmd, Output: strings.TrimSpace(output)}
+		}
+	}
+	return ops, nil
+}
@@ -0,0 +1,202 @@
+package adbutils
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Process
+// @Description: ps 输出的一个进程
+type Process struct {
+	PID  int
+	PPID int
+	User string
+	RSS  int64  // KB
+	VSZ  int64  // KB
+	Name string // 应用进程为包名, 如 com.example.app:push
+	Args string // 完整命令行, 旧设备上与 Name 相同
+}
+
+// Signal 设备端(linux)的信号, 与本机的 syscall.Signal 编号不一定相同, 如 darwin 上 SIGUSR1 为 30
+type Signal int
+
+const (
+	SIGHUP  Signal = 1
+	SIGINT  Signal = 2
+	SIGQUIT Signal = 3
+	SIGABRT Signal = 6
+	SIGKILL Signal = 9
+	SIGUSR1 Signal = 10
+	SIGSEGV Signal = 11
+	SIGUSR2 Signal = 12
+	SIGTERM Signal = 15
+	SIGCONT Signal = 18
+	SIGSTOP Signal = 19
+)
+
+var signalNames = map[Signal]string{
+	SIGHUP: "HUP", SIGINT: "INT", SIGQUIT: "QUIT", SIGABRT: "ABRT", SIGKILL: "KILL", SIGUSR1: "USR1",
+	SIGSEGV: "SEGV", SIGUSR2: "USR2", SIGTERM: "TERM", SIGCONT: "CONT", SIGSTOP: "STOP",
+}
+
+// String 返回不带 SIG 前缀的名称, 如 KILL, 未列出的信号返回编号
+func (s Signal) String() string {
+	if name, ok := signalNames[s]; ok {
+		return name
+	}
+	return strconv.Itoa(int(s))
+}
+
+const psCommand = "ps -A -o PID,PPID,USER,RSS,VSZ,NAME,ARGS"
+
+// Processes
+//
+//	@Description: 列出所有进程, Android 8.0 以上使用 toybox 的 ps -A -o, 旧设备的 toolbox ps 不支持选项时使用 ps 的默认输出
+//	@receiver mixin
+//	@param ctx
+//	@return []Process
+//	@return error
+func (mixin ShellMixin) Processes(ctx context.Context) ([]Process, error) {
+	output, err := mixin.runContext(ctx, psCommand)
+	if err != nil {
+		return nil, err
+	}
+	// toolbox ps 把 -A 当作进程名过滤, 只输出表头
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if fields := strings.Fields(lines[0]); len(fields) == 7 && fields[0] == "PID" && fields[6] == "ARGS" {
+		return parseProcesses(lines[1:]), nil
+	}
+	if output, err = mixin.runContext(ctx, "ps"); err != nil {
+		return nil, err
+	}
+	return parseToolboxProcesses(output)
+}
+
+// parseProcesses 解析 ps -A -o 的输出, 只有最后一列 ARGS 可能含空格
+func parseProcesses(lines []string) []Process {
+	processes := []Process{}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			continue
+		}
+		p := Process{User: fields[2], Name: fields[5]}
+		p.PID, _ = strconv.Atoi(fields[0])
+		p.PPID, _ = strconv.Atoi(fields[1])
+		p.RSS, _ = strconv.ParseInt(fields[3], 10, 64)
+		p.VSZ, _ = strconv.ParseInt(fields[4], 10, 64)
+		if len(fields) > 6 {
+			p.Args = strings.Join(fields[6:], " ")
+		}
+		processes = append(processes, p)
+	}
+	return processes
+}
+
+// parseToolboxProcesses 解析 toolbox ps 的输出, 按表头确定列:
+// USER PID PPID VSIZE RSS [PRIO NICE RTPRI SCHED PCY] WCHAN PC NAME, PC 后还有一列状态
+func parseToolboxProcesses(output string) ([]Process, error) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	header := strings.Fields(lines[0])
+	column := map[string]int{}
+	for i, name := range header {
+		column[name] = i
+	}
+	for _, name := range []string{"USER", "PID", "PPID", "VSIZE", "RSS", "NAME"} {
+		if _, ok := column[name]; !ok {
+			return nil, fmt.Errorf("adb: unknown ps output: %s", strings.TrimSpace(lines[0]))
+		}
+	}
+	processes := []Process{}
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < len(header) {
+			continue
+		}
+		// 状态列没有表头, NAME 总是最后一列
+		p := Process{User: fields[column["USER"]], Name: fields[len(fields)-1]}
+		p.PID, _ = strconv.Atoi(fields[column["PID"]])
+		p.PPID, _ = strconv.Atoi(fields[column["PPID"]])
+		p.VSZ, _ = strconv.ParseInt(fields[column["VSIZE"]], 10, 64)
+		p.RSS, _ = strconv.ParseInt(fields[column["RSS"]], 10, 64)
+		p.Args = p.Name
+		processes = append(processes, p)
+	}
+	return processes, nil
+}
+
+// Pidof
+//
+//	@Description: 返回名称为 name 的进程 id, 没有时为空; 没有 pidof 命令的旧设备上通过 ps 查找
+//	@receiver mixin
+//	@param ctx
+//	@param name 进程名, 应用主进程为包名
+//	@return []int
+//	@return error
+func (mixin ShellMixin) Pidof(ctx context.Context, name string) ([]int, error) {
+	output, err := mixin.runContext(ctx, "pidof "+shellQuote(name))
+	if err != nil {
+		return nil, err
+	}
+	pids := []int{}
+	for _, field := range strings.Fields(output) {
+		pid, err := strconv.Atoi(field)
+		if err != nil {
+			// /system/bin/sh: pidof: not found
+			return mixin.pidofPs(ctx, name)
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+func (mixin ShellMixin) pidofPs(ctx context.Context, name string) ([]int, error) {
+	processes, err := mixin.Processes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pids := []int{}
+	for _, p := range processes {
+		if p.Name == name {
+			pids = append(pids, p.PID)
+		}
+	}
+	return pids, nil
+}
+
+// Kill
+//
+//	@Description: 向进程发送信号, 没有权限(非 root 时只能结束 shell 用户的进程)或进程不存在时返回 *ShellError
+//	@receiver mixin
+//	@param ctx
+//	@param pid
+//	@param signal 如 adbutils.SIGKILL
+//	@return error
+func (mixin ShellMixin) Kill(ctx context.Context, pid int, signal Signal) error {
+	return mixin.runNoOutput(ctx, "kill", "-s", signal.String(), strconv.Itoa(pid))
+}
+
+// KillAll
+//
+//	@Description: 用 SIGKILL 结束所有名称为 name 的进程, 没有时返回 ErrProcessNotFound. 结束应用建议使用 AppStop
+//	@receiver mixin
+//	@param ctx
+//	@param name
+//	@return error
+func (mixin ShellMixin) KillAll(ctx context.Context, name string) error {
+	pids, err := mixin.Pidof(ctx, name)
+	if err != nil {
+		return err
+	}
+	if len(pids) == 0 {
+		return fmt.Errorf("%w: %s", ErrProcessNotFound, name)
+	}
+	for _, pid := range pids {
+		if err := mixin.Kill(ctx, pid, SIGKILL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package adbutils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// progressInterval 两次进度回调之间的最小间隔, 传输结束时总会回调一次
+const progressInterval = 100 * time.Millisecond
+
+// Progress
+// @Description: 单个文件的传输进度
+type Progress struct {
+	Path    string        // 设备上的路径
+	Done    int64         // 已传输的字节数, 续传时包含本地已有的部分
+	Total   int64         // 文件大小, 未知时为 -1; 压缩传输时为压缩前的大小
+	Speed   float64       // 本次传输的平均速度, 字节/秒
+	Elapsed time.Duration // 本次传输已用的时间
+}
+
+// ProgressFunc 传输进度回调, 在传输的协程中同步调用, 不应阻塞
+type ProgressFunc func(p Progress)
+
+// progressTracker
+// @Description: 统计单个文件的传输进度并按间隔回调, 为 nil 时所有方法都不做任何事
+type progressTracker struct {
+	fn     ProgressFunc
+	p      Progress
+	offset int64
+	start  time.Time
+	last   time.Time
+}
+
+// newProgress
+//
+//	@Description: 没有设置 OnProgress 时返回 nil
+//	@receiver sync
+//	@param path 设备上的路径
+//	@param offset 已经传输过的字节数, 续传时为本地文件大小
+//	@param total 文件大小, 未知时为 -1
+//	@return *progressTracker
+func (sync Sync) newProgress(path string, offset, total int64) *progressTracker {
+	if sync.OnProgress == nil {
+		return nil
+	}
+	now := time.Now()
+	return &progressTracker{
+		fn:     sync.OnProgress,
+		p:      Progress{Path: path, Done: offset, Total: total},
+		offset: offset,
+		start:  now,
+		last:   now,
+	}
+}
+
+func (t *progressTracker) add(n int) {
+	if t == nil || n <= 0 {
+		return
+	}
+	t.p.Done += int64(n)
+	if now := time.Now(); now.Sub(t.last) >= progressInterval {
+		t.last = now
+		t.report(now)
+	}
+}
+
+func (t *progressTracker) finish() {
+	if t != nil {
+		t.report(time.Now())
+	}
+}
+
+func (t *progressTracker) report(now time.Time) {
+	t.p.Elapsed = now.Sub(t.start)
+	if t.p.Elapsed > 0 {
+		t.p.Speed = float64(t.p.Done-t.offset) / t.p.Elapsed.Seconds()
+	}
+	t.fn(t.p)
+}
+
+// progressReader 读取时累计进度
+type progressReader struct {
+	r io.Reader
+	t *progressTracker
+}
+
+func (r progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.t.add(n)
+	return n, err
+}
+
+// sizedReader 包装后的 r 无法获取大小时, 附带原始的大小
+type sizedReader struct {
+	io.Reader
+	size int64
+}
+
+// readerSize 返回 r 的剩余大小, 支持 *os.File、*strings.Reader、*bytes.Reader, 未知时为 -1
+func readerSize(r io.Reader) int64 {
+	switch r := r.(type) {
+	case sizedReader:
+		return r.size
+	case interface{ Len() int }:
+		return int64(r.Len())
+	case interface{ Stat() (os.FileInfo, error) }:
+		if info, err := r.Stat(); err == nil && info.Mode().IsRegular() {
+			return info.Size()
+		}
+	}
+	return -1
+}
+
+func (sync Sync) PullResume(src, dst string) (int64, error) {
+	return sync.PullResumeContext(context.Background(), src, dst)
+}
+
+// PullResumeContext
+//
+//	@Description: 断点续传, 本地已有部分文件时只拉取剩余的内容追加到末尾.
+//	sync 协议不支持偏移, 剩余部分通过 exec:tail -c +<offset> 读取; 本地文件不存在、比远端大,
+//	或与远端开头部分的 sha256 不一致时重新完整拉取; 续传失败时本地文件截断回原来的大小
+//	@receiver sync
+//	@param ctx
+//	@param src 设备上的文件
+//	@param dst 本地文件
+//	@return int64 本次拉取的字节数, 不包含本地已有的部分
+//	@return error
+func (sync Sync) PullResumeContext(ctx context.Context, src, dst string) (int64, error) {
+	info, err := sync.StatContext(ctx, src)
+	if err != nil {
+		return 0, err
+	}
+	if info.Mode == 0 {
+		return 0, &fs.PathError{Op: "pull", Path: src, Err: fs.ErrNotExist}
+	}
+	var offset int64
+	if st, err := os.Stat(dst); err == nil {
+		offset = st.Size()
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+	if offset == 0 || offset > info.Size {
+		return sync.pull(ctx, src, dst, info.Size)
+	}
+	same, err := sync.prefixMatches(ctx, src, dst, offset)
+	if err != nil {
+		return 0, err
+	}
+	if !same {
+		return sync.pull(ctx, src, dst, info.Size)
+	}
+	tracker := sync.newProgress(src, offset, info.Size)
+	if offset == info.Size {
+		tracker.finish()
+		return 0, nil
+	}
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	device := sync.AdbClient.Device(SerialNTransportID{Serial: sync.Serial})
+	c, err := device.execStream(ctx, fmt.Sprintf("tail -c +%d %s", offset+1, shellQuote(src)))
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+	n, err := io.CopyBuffer(f, progressReader{c.Conn, tracker}, make([]byte, syncMaxData))
+	if err == nil && offset+n != info.Size {
+		err = fmt.Errorf("adb: resume pull %s incomplete, expect %d bytes, got %d", src, info.Size, offset+n)
+	}
+	if err != nil {
+		// exec: 的输出包含 stderr, tail 失败时错误信息也已写入文件
+		if truncErr := f.Truncate(offset); truncErr != nil {
+			return n, truncErr
+		}
+		return n, err
+	}
+	tracker.finish()
+	return n, nil
+}
+
+// prefixMatches 比较本地文件与设备上文件前 offset 字节的 sha256, 设备上没有 head -c 或 sha256sum 时视为不一致
+func (sync Sync) prefixMatches(ctx context.Context, src, dst string, offset int64) (bool, error) {
+	f, err := os.Open(dst)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, offset); err != nil {
+		return false, err
+	}
+	_, remote, err := sync.shellChecksum(ctx, func(tool string) string {
+		return fmt.Sprintf("head -c %d %s | %s", offset, shellQuote(src), tool)
+	}, ChecksumSHA256)
+	var adbErr *AdbError
+	if errors.As(err, &adbErr) && adbErr.Service == "shell:sha256sum" {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return hex.EncodeToString(h.Sum(nil)) == remote, nil
+}
@@ -23,9 +23,17 @@ type ReverseItem struct {
 
 type FileInfo struct {
 	Mode  int
-	Size  int
+	Size  int64
 	Mtime *time.Time
 	Path  string
+	// 以下字段只有设备支持 stat_v2/ls_v2 时才有值
+	Dev   uint64
+	Ino   uint64
+	Nlink uint32
+	Uid   uint32
+	Gid   uint32
+	Atime *time.Time
+	Ctime *time.Time
 }
 type WindowSize struct {
 	Width  int
@@ -41,7 +49,9 @@ type RunningAppInfo struct {
 type ShellReturn struct {
 	Command    string
 	ReturnCode int
-	Output     string
+	Output     string // stdout 与 stderr 按到达顺序合并
+	Stdout     string
+	Stderr     string
 }
 
 type AdbDeviceInfo struct {
@@ -0,0 +1,115 @@
+package adbutils
+
+import (
+	"context"
+	"strings"
+)
+
+// openReverse
+//
+//	@Description: 切换到设备后打开 reverse: 服务, 第一个 OKAY 表示设备端服务已打开
+//	@receiver adbDevice
+//	@param ctx
+//	@param command 如 forward:tcp:8000;tcp:9000、list-forward
+//	@return *AdbConnection
+//	@return error
+func (adbDevice AdbDevice) openReverse(ctx context.Context, command string) (*AdbConnection, error) {
+	c, err := adbDevice.openTransport(ctx, "", adbDevice.Client.SocketTime)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.SendCommand("reverse:" + command); err == nil {
+		err = c.CheckOkay()
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// reverseCommand 执行不返回内容的 reverse 命令, 设备端第二个 OKAY 才是执行结果
+func (adbDevice AdbDevice) reverseCommand(ctx context.Context, command string) error {
+	c, err := adbDevice.openReverse(ctx, command)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.CheckOkay()
+}
+
+// Reverse
+//
+//	@Description: 将设备上的 remote 地址转发到电脑上的 local 地址, 设备上的程序可以通过 remote 访问电脑上的服务
+//	@receiver adbDevice
+//	@param remote 设备端地址, 如 tcp:8000、localabstract:agent
+//	@param local 电脑端地址, 如 tcp:9000
+//	@param noRebind remote 已存在转发时返回错误而不是覆盖
+//	@return error
+func (adbDevice AdbDevice) Reverse(remote, local string, noRebind bool) error {
+	return adbDevice.ReverseContext(context.Background(), remote, local, noRebind)
+}
+
+func (adbDevice AdbDevice) ReverseContext(ctx context.Context, remote, local string, noRebind bool) error {
+	args := []string{"forward"}
+	if noRebind {
+		args = append(args, "norebind")
+	}
+	args = append(args, remote+";"+local)
+	return adbDevice.reverseCommand(ctx, strings.Join(args, ":"))
+}
+
+// ReverseList
+//
+//	@Description: 列出设备上已有的 reverse 转发
+//	@receiver adbDevice
+//	@return []ReverseItem
+//	@return error
+func (adbDevice AdbDevice) ReverseList() ([]ReverseItem, error) {
+	return adbDevice.ReverseListContext(context.Background())
+}
+
+func (adbDevice AdbDevice) ReverseListContext(ctx context.Context) ([]ReverseItem, error) {
+	c, err := adbDevice.openReverse(ctx, "list-forward")
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	content, err := c.ReadStringBlock()
+	if err != nil {
+		return nil, err
+	}
+	items := []ReverseItem{}
+	for _, line := range strings.Split(content, "\n") {
+		// 每行格式为: <transport> <remote> <local>
+		parts := strings.Fields(line)
+		if len(parts) != 3 {
+			continue
+		}
+		items = append(items, ReverseItem{Remote: parts[1], Local: parts[2]})
+	}
+	return items, nil
+}
+
+// ReverseRemove
+//
+//	@Description: 删除设备上 remote 地址的 reverse 转发
+//	@receiver adbDevice
+//	@param remote
+//	@return error
+func (adbDevice AdbDevice) ReverseRemove(remote string) error {
+	return adbDevice.ReverseRemoveContext(context.Background(), remote)
+}
+
+func (adbDevice AdbDevice) ReverseRemoveContext(ctx context.Context, remote string) error {
+	return adbDevice.reverseCommand(ctx, "killforward:"+remote)
+}
+
+// ReverseRemoveAll 删除设备上所有的 reverse 转发
+func (adbDevice AdbDevice) ReverseRemoveAll() error {
+	return adbDevice.ReverseRemoveAllContext(context.Background())
+}
+
+func (adbDevice AdbDevice) ReverseRemoveAllContext(ctx context.Context) error {
+	return adbDevice.reverseCommand(ctx, "killforward-all")
+}
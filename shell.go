@@ -0,0 +1,315 @@
+package adbutils
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// shell v2 协议包类型, 每个包为 1字节类型 + 4字节小端长度 + 内容
+const (
+	shellStdin            byte = 0
+	shellStdout           byte = 1
+	shellStderr           byte = 2
+	shellExit             byte = 3
+	shellCloseStdin       byte = 4
+	shellWindowSizeChange byte = 5
+)
+
+const (
+	FeatureShellV2 = "shell_v2"
+	// exitSentinel 不支持 shell v2 的设备上, 另起一行追加到命令后用来取得退出码, 不受命令结尾的 &、; 或注释影响
+	exitSentinel = "ADBUTILS_EXIT:"
+)
+
+func writeShellPacket(w io.Writer, id byte, data []byte) error {
+	msg := make([]byte, 5, 5+len(data))
+	msg[0] = id
+	binary.LittleEndian.PutUint32(msg[1:], uint32(len(data)))
+	_, err := w.Write(append(msg, data...))
+	return err
+}
+
+func readShellPacket(r io.Reader) (byte, []byte, error) {
+	head := make([]byte, 5)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, nil, err
+	}
+	data := make([]byte, binary.LittleEndian.Uint32(head[1:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+	return head[0], data, nil
+}
+
+// hasFeature 判断 features 服务返回的逗号分隔列表中是否包含指定特性
+func hasFeature(features, name string) bool {
+	for _, f := range strings.Split(features, ",") {
+		if strings.TrimSpace(f) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Shell2
+//
+//	@Description: 执行shell指令并返回退出码, 设备支持 shell_v2 时 stdout 和 stderr 分开返回,
+//	否则使用旧版 shell 服务并通过 echo $? 取得退出码, 此时 stderr 合并在 Stdout 中
+//	@receiver adbDevice
+//	@param cmdargs
+//	@return *ShellReturn
+//	@return error
+func (adbDevice AdbDevice) Shell2(cmdargs string) (*ShellReturn, error) {
+	return adbDevice.Shell2Context(context.Background(), cmdargs)
+}
+
+func (adbDevice AdbDevice) Shell2Context(ctx context.Context, cmdargs string) (*ShellReturn, error) {
+	features, err := adbDevice.GetFeaturesContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if hasFeature(features, FeatureShellV2) {
+		return adbDevice.shellV2(ctx, cmdargs)
+	}
+	return adbDevice.shellWithSentinel(ctx, cmdargs)
+}
+
+func (adbDevice AdbDevice) shellV2(ctx context.Context, cmdargs string) (*ShellReturn, error) {
+	c, err := adbDevice.openTransport(ctx, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	if err = c.SendCommand("shell,v2,raw:" + cmdargs); err != nil {
+		return nil, err
+	}
+	if err = c.CheckOkay(); err != nil {
+		return nil, err
+	}
+	// 不提供输入, 立即关闭 stdin 避免读取 stdin 的命令阻塞
+	if err = writeShellPacket(c.Conn, shellCloseStdin, nil); err != nil {
+		return nil, err
+	}
+	var output, stdout, stderr bytes.Buffer
+	for {
+		id, data, err := readShellPacket(c.Conn)
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case shellStdout:
+			stdout.Write(data)
+			output.Write(data)
+		case shellStderr:
+			stderr.Write(data)
+			output.Write(data)
+		case shellExit:
+			ret := &ShellReturn{
+				Command: cmdargs,
+				Output:  output.String(),
+				Stdout:  stdout.String(),
+				Stderr:  stderr.String(),
+			}
+			if len(data) > 0 {
+				ret.ReturnCode = int(data[0])
+			}
+			return ret, nil
+		}
+	}
+}
+
+func (adbDevice AdbDevice) shellWithSentinel(ctx context.Context, cmdargs string) (*ShellReturn, error) {
+	output, err := adbDevice.ShellContext(ctx, cmdargs+"\necho "+exitSentinel+"$?")
+	if err != nil {
+		return nil, err
+	}
+	ret := &ShellReturn{Command: cmdargs, ReturnCode: -1}
+	i := strings.LastIndex(output, exitSentinel)
+	if i < 0 {
+		ret.Output, ret.Stdout = output, output
+		return ret, nil
+	}
+	ret.Output, ret.Stdout = output[:i], output[:i]
+	if code, err := strconv.Atoi(strings.TrimSpace(output[i+len(exitSentinel):])); err == nil {
+		ret.ReturnCode = code
+	}
+	return ret, nil
+}
+
+// shellMaxPacket 写入 stdin 时单个包的最大长度
+const shellMaxPacket = 16 * 1024
+
+// ShellOptions
+// @Description: OpenShell 的参数
+type ShellOptions struct {
+	Command string // 为空时打开交互式登录 shell
+	Raw     bool   // 不分配 PTY, stdout 和 stderr 分开输出
+	Term    string // PTY 模式下的 TERM, 默认 xterm-256color
+	Rows    int    // 初始窗口大小, 为 0 时不设置
+	Cols    int
+}
+
+// ShellSession
+// @Description: 基于 shell v2 的交互式 shell 会话, 调用方需要持续读取 Stdout 和 Stderr, 否则会阻塞后续输出
+type ShellSession struct {
+	Stdin  io.WriteCloser
+	Stdout io.Reader
+	Stderr io.Reader
+
+	conn     *AdbConnection
+	writeMu  sync.Mutex
+	done     chan struct{}
+	exitCode int
+	err      error
+}
+
+// OpenShell
+//
+//	@Description: 打开交互式 shell 会话, 需要设备支持 shell_v2; ctx 结束时会话被关闭
+//	@receiver adbDevice
+//	@param ctx
+//	@param opts
+//	@return *ShellSession
+//	@return error
+func (adbDevice AdbDevice) OpenShell(ctx context.Context, opts ShellOptions) (*ShellSession, error) {
+	features, err := adbDevice.GetFeaturesContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !hasFeature(features, FeatureShellV2) {
+		return nil, fmt.Errorf("%w: %s", ErrNotSupported, FeatureShellV2)
+	}
+	service := "shell,v2,raw:" + opts.Command
+	if !opts.Raw {
+		term := opts.Term
+		if term == "" {
+			term = "xterm-256color"
+		}
+		service = fmt.Sprintf("shell,v2,TERM=%s,pty:%s", term, opts.Command)
+	}
+	c, err := adbDevice.openTransport(ctx, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.SendCommand(service); err == nil {
+		err = c.CheckOkay()
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	stdout, stdoutWriter := io.Pipe()
+	stderr, stderrWriter := io.Pipe()
+	session := &ShellSession{
+		Stdout: stdout,
+		Stderr: stderr,
+		conn:   c,
+		done:   make(chan struct{}),
+	}
+	session.Stdin = shellStdinWriter{session}
+	if opts.Rows > 0 && opts.Cols > 0 {
+		if err = session.Resize(opts.Rows, opts.Cols); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	go session.readLoop(stdoutWriter, stderrWriter)
+	return session, nil
+}
+
+func (session *ShellSession) readLoop(stdout, stderr *io.PipeWriter) {
+	err := io.ErrUnexpectedEOF
+	defer func() {
+		stdout.CloseWithError(err)
+		stderr.CloseWithError(err)
+		close(session.done)
+	}()
+	for {
+		id, data, readErr := readShellPacket(session.conn.Conn)
+		if readErr != nil {
+			if readErr != io.EOF {
+				err = readErr
+			}
+			session.err = err
+			return
+		}
+		switch id {
+		case shellStdout:
+			_, _ = stdout.Write(data)
+		case shellStderr:
+			_, _ = stderr.Write(data)
+		case shellExit:
+			if len(data) > 0 {
+				session.exitCode = int(data[0])
+			}
+			err = io.EOF
+			return
+		}
+	}
+}
+
+func (session *ShellSession) writePacket(id byte, data []byte) error {
+	session.writeMu.Lock()
+	defer session.writeMu.Unlock()
+	return writeShellPacket(session.conn.Conn, id, data)
+}
+
+// Resize
+//
+//	@Description: 通知设备端 PTY 窗口大小变化
+//	@receiver session
+//	@param rows
+//	@param cols
+//	@return error
+func (session *ShellSession) Resize(rows, cols int) error {
+	return session.writePacket(shellWindowSizeChange, []byte(fmt.Sprintf("%dx%d,%dx%d", rows, cols, 0, 0)))
+}
+
+// Wait
+//
+//	@Description: 等待命令结束并返回退出码, 连接在收到退出码之前断开时返回错误
+//	@receiver session
+//	@return int
+//	@return error
+func (session *ShellSession) Wait() (int, error) {
+	<-session.done
+	session.conn.Close()
+	return session.exitCode, session.err
+}
+
+// Close 关闭会话, 设备端的进程会收到 SIGHUP
+func (session *ShellSession) Close() error {
+	session.conn.Close()
+	return nil
+}
+
+type shellStdinWriter struct {
+	session *ShellSession
+}
+
+func (w shellStdinWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > shellMaxPacket {
+			n = shellMaxPacket
+		}
+		if err := w.session.writePacket(shellStdin, p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close 发送 close-stdin, 设备端的命令读到 EOF
+func (w shellStdinWriter) Close() error {
+	return w.session.writePacket(shellCloseStdin, nil)
+}
@@ -0,0 +1,299 @@
+package adbutils
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sync v2 相关的设备 feature
+const (
+	FeatureStatV2     = "stat_v2"
+	FeatureLsV2       = "ls_v2"
+	FeatureSendRecvV2 = "sendrecv_v2"
+)
+
+const (
+	STA2 = "STA2"
+	LST2 = "LST2"
+	LIS2 = "LIS2"
+	DNT2 = "DNT2"
+	SND2 = "SND2"
+	RCV2 = "RCV2"
+)
+
+// syncMaxData 与 adbd 一致, 单个 DATA 包最大 64K
+const syncMaxData = 64 * 1024
+
+// statV2Size STA2/LST2 回复中 id 之后的长度: error, dev, ino, mode, nlink, uid, gid, size, atime, mtime, ctime
+const statV2Size = 68
+
+// linuxErrno 设备端是 linux, 不能用本机的 syscall.Errno 描述, 这里只列出 sync 中常见的
+var linuxErrno = map[uint32]string{
+	1:  "Operation not permitted",
+	2:  "No such file or directory",
+	13: "Permission denied",
+	20: "Not a directory",
+	36: "File name too long",
+	40: "Too many levels of symbolic links",
+}
+
+const errnoNoEnt = 2
+
+// syncFeatures
+// @Description: 缓存设备的 feature, Sync 是值类型, 通过指针在拷贝之间共享; 获取失败时不缓存
+type syncFeatures struct {
+	mu       sync.Mutex
+	loaded   bool
+	features string
+}
+
+func (f *syncFeatures) get(load func() (string, error)) (string, error) {
+	if f == nil {
+		return load()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.loaded {
+		return f.features, nil
+	}
+	features, err := load()
+	if err != nil {
+		return "", err
+	}
+	f.features, f.loaded = features, true
+	return features, nil
+}
+
+// supports
+//
+//	@Description: 设备是否支持指定的 sync feature, 如 stat_v2、ls_v2、sendrecv_v2
+//	@receiver sync
+//	@param ctx
+//	@param feature
+//	@return bool
+//	@return error
+func (sync Sync) supports(ctx context.Context, feature string) (bool, error) {
+	features, err := sync.deviceFeatures(ctx)
+	if err != nil {
+		return false, err
+	}
+	return hasFeature(features, feature), nil
+}
+
+func (sync Sync) deviceFeatures(ctx context.Context) (string, error) {
+	return sync.features.get(func() (string, error) {
+		return sync.AdbClient.Device(SerialNTransportID{Serial: sync.Serial}).GetFeaturesContext(ctx)
+	})
+}
+
+// Lstat
+//
+//	@Description: 同 Stat, 但不跟随符号链接; 设备不支持 stat_v2 时使用 v1 的 STAT(adbd 中本就是 lstat)
+//	@receiver sync
+//	@param path
+//	@return *FileInfo
+//	@return error
+func (sync Sync) Lstat(path string) (*FileInfo, error) {
+	return sync.LstatContext(context.Background(), path)
+}
+
+func (sync Sync) LstatContext(ctx context.Context, path string) (*FileInfo, error) {
+	v2, err := sync.supports(ctx, FeatureStatV2)
+	if err != nil {
+		return nil, err
+	}
+	if !v2 {
+		return sync.statV1(ctx, path)
+	}
+	return sync.statV2(ctx, path, LST2)
+}
+
+// statV2
+//
+//	@Description: 使用 STA2/LST2 获取 64 位的文件信息, 文件不存在时与 v1 一致返回零值的 FileInfo
+//	@receiver sync
+//	@param ctx
+//	@param path
+//	@param cmd STA2 或 LST2
+//	@return *FileInfo
+//	@return error
+func (sync Sync) statV2(ctx context.Context, path, cmd string) (*FileInfo, error) {
+	c, err := sync.prepareSync(ctx, path, cmd)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	id, err := c.ReadString(4)
+	if err != nil {
+		return nil, err
+	}
+	if id != cmd {
+		return nil, fmt.Errorf("adb: unexpected sync response %q for %s", id, cmd)
+	}
+	errno, fileInfo, err := sync.readStatV2(c)
+	if err != nil {
+		return nil, err
+	}
+	fileInfo.Path = path
+	switch errno {
+	case 0:
+		return &fileInfo, nil
+	case errnoNoEnt:
+		return &FileInfo{Path: path}, nil
+	default:
+		return nil, syncErrnoError(cmd, path, errno)
+	}
+}
+
+// readStatV2 读取 STA2/LST2/DNT2 中 id 之后的 stat 结构, 返回 errno 和文件信息
+func (sync Sync) readStatV2(c *AdbConnection) (uint32, FileInfo, error) {
+	bs, err := c.Read(statV2Size)
+	if err != nil {
+		return 0, FileInfo{}, err
+	}
+	le := binary.LittleEndian
+	fileInfo := FileInfo{
+		Dev:   le.Uint64(bs[4:]),
+		Ino:   le.Uint64(bs[12:]),
+		Mode:  int(le.Uint32(bs[20:])),
+		Nlink: le.Uint32(bs[24:]),
+		Uid:   le.Uint32(bs[28:]),
+		Gid:   le.Uint32(bs[32:]),
+		Size:  int64(le.Uint64(bs[36:])),
+		Atime: unixTime(int64(le.Uint64(bs[44:]))),
+		Mtime: unixTime(int64(le.Uint64(bs[52:]))),
+		Ctime: unixTime(int64(le.Uint64(bs[60:]))),
+	}
+	return le.Uint32(bs), fileInfo, nil
+}
+
+// listV2 使用 LIS2 列出目录, 每一项为 DNT2 + stat 结构 + 名称长度 + 名称, 以 DONE 结束
+func (sync Sync) listV2(ctx context.Context, path string) ([]FileInfo, error) {
+	c, err := sync.prepareSync(ctx, path, LIS2)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	fileInfos := []FileInfo{}
+	for {
+		response, err := c.ReadString(4)
+		if err != nil {
+			return nil, err
+		}
+		switch response {
+		case DONE:
+			return fileInfos, nil
+		case FAIL:
+			return nil, sync.readSyncFail(c)
+		case DNT2:
+		default:
+			return nil, fmt.Errorf("adb: unexpected sync response %q for LIS2", response)
+		}
+		// 单个文件 lstat 失败时 adbd 仍会返回该项, errno 非零, 与 v1 一样保留名称
+		_, fileInfo, err := sync.readStatV2(c)
+		if err != nil {
+			return nil, err
+		}
+		res, err := sync.readUint32s(c, 1)
+		if err != nil {
+			return nil, err
+		}
+		name, err := c.Read(int(res[0]))
+		if err != nil {
+			return nil, err
+		}
+		fileInfo.Path = string(name)
+		fileInfos = append(fileInfos, fileInfo)
+	}
+}
+
+func syncErrnoError(cmd, path string, errno uint32) error {
+	msg, ok := linuxErrno[errno]
+	if !ok {
+		msg = fmt.Sprintf("errno %d", errno)
+	}
+	return &AdbError{Service: cmd, Message: fmt.Sprintf("%s: %s", path, msg)}
+}
+
+func unixTime(sec int64) *time.Time {
+	if sec == 0 {
+		return nil
+	}
+	t := time.Unix(sec, 0)
+	return &t
+}
+
+// prepareSend
+//
+//	@Description: 打开 SEND 请求, 设备支持 sendrecv_v2 时使用 SND2, 路径与 mode 分开发送, 并按 sync.Compression 协商压缩
+//	@receiver sync
+//	@param ctx
+//	@param dst
+//	@param mode 包含文件类型的 unix mode
+//	@return *AdbConnection
+//	@return Compression 实际使用的压缩算法, 之后的 DATA 需要按此压缩
+//	@return error
+func (sync Sync) prepareSend(ctx context.Context, dst string, mode int) (*AdbConnection, Compression, error) {
+	features, err := sync.deviceFeatures(ctx)
+	if err != nil {
+		return nil, CompressionNone, err
+	}
+	codec, err := resolveCompression(sync.Compression, features)
+	if err != nil {
+		return nil, CompressionNone, err
+	}
+	if !hasFeature(features, FeatureSendRecvV2) {
+		c, err := sync.prepareSync(ctx, dst+","+strconv.Itoa(mode), "SEND")
+		return c, CompressionNone, err
+	}
+	c, err := sync.prepareSync(ctx, dst, SND2)
+	if err != nil {
+		return nil, CompressionNone, err
+	}
+	// SND2 + mode + flags
+	if _, err = c.Conn.Write(syncRequest(SND2, uint32(mode), codec.flag())); err != nil {
+		c.Close()
+		return nil, CompressionNone, err
+	}
+	return c, codec, nil
+}
+
+// prepareRecv 打开 RECV 请求, 设备支持 sendrecv_v2 时使用 RCV2 并协商压缩, 回复格式与 RECV 相同
+func (sync Sync) prepareRecv(ctx context.Context, src string) (*AdbConnection, Compression, error) {
+	features, err := sync.deviceFeatures(ctx)
+	if err != nil {
+		return nil, CompressionNone, err
+	}
+	codec, err := resolveCompression(sync.Compression, features)
+	if err != nil {
+		return nil, CompressionNone, err
+	}
+	if !hasFeature(features, FeatureSendRecvV2) {
+		c, err := sync.prepareSync(ctx, src, "RECV")
+		return c, CompressionNone, err
+	}
+	c, err := sync.prepareSync(ctx, src, RCV2)
+	if err != nil {
+		return nil, CompressionNone, err
+	}
+	// RCV2 + flags
+	if _, err = c.Conn.Write(syncRequest(RCV2, codec.flag())); err != nil {
+		c.Close()
+		return nil, CompressionNone, err
+	}
+	return c, codec, nil
+}
+
+// syncRequest 拼接 id 和若干 4 字节小端整数
+func syncRequest(id string, values ...uint32) []byte {
+	msg := make([]byte, 4+4*len(values))
+	copy(msg, id)
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(msg[4+4*i:], v)
+	}
+	return msg
+}
@@ -0,0 +1,488 @@
+package adbutils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SymlinkMode
+// @Description: PushDir/PullDir 处理符号链接的方式
+type SymlinkMode int
+
+const (
+	SymlinkSkip     SymlinkMode = iota // 忽略符号链接
+	SymlinkFollow                      // 传输链接指向的文件或目录
+	SymlinkPreserve                    // 在目标端重新创建符号链接
+)
+
+// maxSymlinkDepth 跟随符号链接目录的最大层数, 防止链接成环
+const maxSymlinkDepth = 8
+
+// mkdirBatchSize 单条 mkdir -p 命令的最大长度
+const mkdirBatchSize = 1024
+
+// SyncDirOptions
+// @Description: PushDir/PullDir 的选项, 匹配规则使用 path.Match, 不含 / 的模式匹配文件名, 否则匹配相对路径
+type SyncDirOptions struct {
+	Include  []string    // 只传输匹配的文件, 为空时传输所有文件
+	Exclude  []string    // 跳过匹配的文件和目录
+	Symlinks SymlinkMode // 零值为忽略符号链接
+	// 与 adb push --sync 一致, 只传输大小或修改时间不同的文件
+	Sync bool
+}
+
+// SyncDirResult
+// @Description: PushDir/PullDir 的传输结果
+type SyncDirResult struct {
+	Files   int   // 传输的文件数(包括符号链接)
+	Skipped int   // Sync 模式下未变化而跳过的文件数
+	Bytes   int64 // 传输的字节数
+}
+
+func (opts SyncDirOptions) validate() error {
+	for _, p := range append(append([]string(nil), opts.Include...), opts.Exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("bad pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func (opts SyncDirOptions) excluded(rel string) bool {
+	return matchAny(opts.Exclude, rel)
+}
+
+func (opts SyncDirOptions) included(rel string) bool {
+	return len(opts.Include) == 0 || matchAny(opts.Include, rel)
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		name := rel
+		if !strings.Contains(p, "/") {
+			name = path.Base(rel)
+		}
+		// 模式已在 validate 中检查过
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// unchanged Sync 模式下比较大小和秒级修改时间
+func unchanged(size int64, mtime time.Time, other *FileInfo) bool {
+	return other != nil && other.Mtime != nil && other.Size == size && other.Mtime.Unix() == mtime.Unix()
+}
+
+// shellQuote 用单引号包裹参数, 供设备上的 sh 使用
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// region push dir
+
+type pushFile struct {
+	local  string
+	remote string
+	info   os.FileInfo
+}
+
+type pushLink struct {
+	target string
+	remote string
+	mtime  time.Time
+}
+
+// pushDir 推送时创建的目录, 全部文件推送完成后再设置权限和修改时间
+type pushDir struct {
+	remote string
+	info   os.FileInfo
+}
+
+// pushPlan 先遍历本地目录得到需要创建的目录和传输的文件, 再统一传输
+type pushPlan struct {
+	opts    SyncDirOptions
+	dirs    []pushDir
+	files   []pushFile
+	links   []pushLink
+	visited map[string]bool
+}
+
+func (p *pushPlan) walk(local, remote, rel string) error {
+	entries, err := os.ReadDir(local)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		childLocal := filepath.Join(local, e.Name())
+		childRemote := path.Join(remote, e.Name())
+		childRel := path.Join(rel, e.Name())
+		if p.opts.excluded(childRel) {
+			continue
+		}
+		info, err := os.Lstat(childLocal)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			switch p.opts.Symlinks {
+			case SymlinkSkip:
+				continue
+			case SymlinkPreserve:
+				if !p.opts.included(childRel) {
+					continue
+				}
+				target, err := os.Readlink(childLocal)
+				if err != nil {
+					return err
+				}
+				p.links = append(p.links, pushLink{target: target, remote: childRemote, mtime: info.ModTime()})
+				continue
+			case SymlinkFollow:
+				if info, err = os.Stat(childLocal); err != nil {
+					// 失效的链接
+					continue
+				}
+			}
+		}
+		if info.IsDir() {
+			real, err := filepath.EvalSymlinks(childLocal)
+			if err != nil {
+				return err
+			}
+			if p.visited[real] {
+				continue
+			}
+			p.visited[real] = true
+			p.dirs = append(p.dirs, pushDir{remote: childRemote, info: info})
+			if err := p.walk(childLocal, childRemote, childRel); err != nil {
+				return err
+			}
+			continue
+		}
+		// 跳过 socket、设备等特殊文件
+		if !info.Mode().IsRegular() || !p.opts.included(childRel) {
+			continue
+		}
+		p.files = append(p.files, pushFile{local: childLocal, remote: childRemote, info: info})
+	}
+	return nil
+}
+
+// PushDir
+//
+//	@Description: 递归推送本地目录到设备, 保留文件和目录的权限和修改时间, 与 adb push 一样先用 mkdir -p 创建目录
+//	@receiver sync
+//	@param ctx
+//	@param localDir
+//	@param remoteDir 设备上的目标目录, localDir 下的内容推送到该目录下
+//	@param opts
+//	@return SyncDirResult
+//	@return error
+func (sync Sync) PushDir(ctx context.Context, localDir, remoteDir string, opts SyncDirOptions) (SyncDirResult, error) {
+	var res SyncDirResult
+	if err := opts.validate(); err != nil {
+		return res, err
+	}
+	real, err := filepath.EvalSymlinks(localDir)
+	if err != nil {
+		return res, err
+	}
+	root, err := os.Stat(real)
+	if err != nil {
+		return res, err
+	}
+	p := &pushPlan{opts: opts, dirs: []pushDir{{remote: remoteDir, info: root}}, visited: map[string]bool{real: true}}
+	if err := p.walk(localDir, remoteDir, ""); err != nil {
+		return res, err
+	}
+	dirs := make([]string, len(p.dirs))
+	for i, d := range p.dirs {
+		dirs[i] = d.remote
+	}
+	if err := sync.mkdirs(ctx, dirs); err != nil {
+		return res, err
+	}
+	// Sync 模式下每个目录只 LIST 一次
+	listings := map[string]map[string]*FileInfo{}
+	remoteInfo := func(remote string) (*FileInfo, error) {
+		dir := path.Dir(remote)
+		if _, ok := listings[dir]; !ok {
+			list, err := sync.IterDirectoryContext(ctx, dir)
+			if err != nil {
+				return nil, err
+			}
+			m := map[string]*FileInfo{}
+			for i := range *list {
+				m[(*list)[i].Path] = &(*list)[i]
+			}
+			listings[dir] = m
+		}
+		return listings[dir][path.Base(remote)], nil
+	}
+	for _, f := range p.files {
+		if opts.Sync {
+			info, err := remoteInfo(f.remote)
+			if err != nil {
+				return res, err
+			}
+			if unchanged(f.info.Size(), f.info.ModTime(), info) {
+				res.Skipped++
+				continue
+			}
+		}
+		n, err := sync.pushFile(ctx, f.local, f.remote, int(f.info.Mode().Perm()), f.info.ModTime())
+		res.Bytes += n
+		if err != nil {
+			return res, err
+		}
+		res.Files++
+	}
+	for _, l := range p.links {
+		n, err := sync.send(ctx, strings.NewReader(l.target), l.remote, syscall.S_IFLNK|0777, l.mtime)
+		res.Bytes += n
+		if err != nil {
+			return res, err
+		}
+		res.Files++
+	}
+	return res, sync.chdirs(ctx, p.dirs)
+}
+
+func (sync Sync) pushFile(ctx context.Context, local, remote string, mode int, mtime time.Time) (int64, error) {
+	f, err := os.Open(local)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return sync.send(ctx, f, remote, syscall.S_IFREG|mode, mtime)
+}
+
+// mkdirs 分批执行 mkdir -p, 成功时没有输出
+func (sync Sync) mkdirs(ctx context.Context, dirs []string) error {
+	for len(dirs) > 0 {
+		cmd := "mkdir -p"
+		for len(dirs) > 0 && (cmd == "mkdir -p" || len(cmd)+len(dirs[0]) < mkdirBatchSize) {
+			cmd += " " + shellQuote(dirs[0])
+			dirs = dirs[1:]
+		}
+		output, err := sync.AdbClient.ShellContext(ctx, sync.Serial, cmd)
+		if err != nil {
+			return err
+		}
+		if output != "" {
+			return &AdbError{Service: "shell:mkdir", Message: output}
+		}
+	}
+	return nil
+}
+
+// chdirs 分批执行 chmod 和 touch 设置目录的权限和修改时间, dirs 中父目录在子目录之前, 从后往前设置; 成功时没有输出
+func (sync Sync) chdirs(ctx context.Context, dirs []pushDir) error {
+	for len(dirs) > 0 {
+		cmd := ""
+		for len(dirs) > 0 {
+			d := dirs[len(dirs)-1]
+			name := shellQuote(d.remote)
+			next := fmt.Sprintf("chmod %o %s; touch -m -d @%d %s", d.info.Mode().Perm(), name, d.info.ModTime().Unix(), name)
+			if cmd != "" {
+				if len(cmd)+len(next) >= mkdirBatchSize {
+					break
+				}
+				next = "; " + next
+			}
+			cmd += next
+			dirs = dirs[:len(dirs)-1]
+		}
+		output, err := sync.AdbClient.ShellContext(ctx, sync.Serial, cmd)
+		if err != nil {
+			return err
+		}
+		if output != "" {
+			return &AdbError{Service: "shell:chmod", Message: output}
+		}
+	}
+	return nil
+}
+
+// end region push dir
+
+// region pull dir
+
+// pulledDir 拉取时创建的本地目录, 全部文件拉取完成后再设置权限和修改时间
+type pulledDir struct {
+	local string
+	info  FileInfo
+}
+
+// PullDir
+//
+//	@Description: 递归拉取设备目录到本地, 保留文件和目录的权限和修改时间
+//	@receiver sync
+//	@param ctx
+//	@param remoteDir
+//	@param localDir remoteDir 下的内容拉取到该目录下, 不存在时自动创建
+//	@param opts
+//	@return SyncDirResult
+//	@return error
+func (sync Sync) PullDir(ctx context.Context, remoteDir, localDir string, opts SyncDirOptions) (SyncDirResult, error) {
+	var res SyncDirResult
+	if err := opts.validate(); err != nil {
+		return res, err
+	}
+	var dirs []pulledDir
+	if err := sync.pullDir(ctx, remoteDir, localDir, "", opts, &res, 0, &dirs); err != nil {
+		return res, err
+	}
+	// 写入文件会改变目录的修改时间, 只读目录也无法再写入, 因此最后设置, dirs 中子目录在父目录之前
+	for _, d := range dirs {
+		if err := os.Chmod(d.local, os.FileMode(d.info.Mode&0777)); err != nil {
+			return res, err
+		}
+		if d.info.Mtime != nil {
+			if err := os.Chtimes(d.local, *d.info.Mtime, *d.info.Mtime); err != nil {
+				return res, err
+			}
+		}
+	}
+	return res, nil
+}
+
+func (sync Sync) pullDir(ctx context.Context, remote, local, rel string, opts SyncDirOptions, res *SyncDirResult, depth int, dirs *[]pulledDir) error {
+	if err := os.MkdirAll(local, 0755); err != nil {
+		return err
+	}
+	list, err := sync.IterDirectoryContext(ctx, remote)
+	if err != nil {
+		return err
+	}
+	// 列表中的 . 即目录本身, 跟随链接时为链接指向的目录
+	var self *FileInfo
+	for _, entry := range *list {
+		if entry.Path == "." {
+			if entry.Mode&syscall.S_IFMT == syscall.S_IFDIR {
+				info := entry
+				self = &info
+			}
+			continue
+		}
+		if entry.Path == ".." {
+			continue
+		}
+		childRemote := path.Join(remote, entry.Path)
+		childLocal := filepath.Join(local, entry.Path)
+		childRel := path.Join(rel, entry.Path)
+		if opts.excluded(childRel) {
+			continue
+		}
+		info := entry
+		childDepth := depth
+		if info.Mode&syscall.S_IFMT == syscall.S_IFLNK {
+			switch opts.Symlinks {
+			case SymlinkSkip:
+				continue
+			case SymlinkPreserve:
+				if !opts.included(childRel) {
+					continue
+				}
+				if err := sync.pullLink(ctx, childRemote, childLocal); err != nil {
+					return err
+				}
+				res.Files++
+				continue
+			case SymlinkFollow:
+				st, err := sync.StatContext(ctx, childRemote)
+				if err != nil {
+					return err
+				}
+				if st.Mode == 0 {
+					// 失效的链接
+					continue
+				}
+				// 不支持 stat_v2 时 STAT 不跟随链接, 按普通文件读取内容
+				info = *st
+				childDepth++
+			}
+		}
+		if info.Mode&syscall.S_IFMT == syscall.S_IFDIR {
+			if childDepth > maxSymlinkDepth {
+				continue
+			}
+			if err := sync.pullDir(ctx, childRemote, childLocal, childRel, opts, res, childDepth, dirs); err != nil {
+				return err
+			}
+			continue
+		}
+		if mode := info.Mode & syscall.S_IFMT; (mode != syscall.S_IFREG && mode != syscall.S_IFLNK) || !opts.included(childRel) {
+			continue
+		}
+		if opts.Sync && info.Mtime != nil {
+			if st, err := os.Stat(childLocal); err == nil && unchanged(st.Size(), st.ModTime(), &info) {
+				res.Skipped++
+				continue
+			}
+		}
+		n, err := sync.pullFile(ctx, childRemote, childLocal, info)
+		res.Bytes += n
+		if err != nil {
+			return err
+		}
+		res.Files++
+	}
+	// 子目录已在递归中加入, 父目录排在其后
+	if self != nil {
+		*dirs = append(*dirs, pulledDir{local: local, info: *self})
+	}
+	return nil
+}
+
+// pullFile 拉取文件后设置权限和修改时间, 目标已存在时先删除, 避免之前拉取的只读文件无法覆盖
+func (sync Sync) pullFile(ctx context.Context, remote, local string, info FileInfo) (int64, error) {
+	if err := os.Remove(local); err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	total := int64(-1)
+	if info.Mode&syscall.S_IFMT == syscall.S_IFREG {
+		total = info.Size
+	}
+	n, err := sync.pull(ctx, remote, local, total)
+	if err != nil {
+		return n, err
+	}
+	if info.Mode&syscall.S_IFMT == syscall.S_IFREG {
+		if err := os.Chmod(local, os.FileMode(info.Mode&0777)); err != nil {
+			return n, err
+		}
+	}
+	if info.Mtime != nil {
+		if err := os.Chtimes(local, *info.Mtime, *info.Mtime); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// pullLink 通过 readlink 读取设备上的链接目标, 在本地重新创建
+func (sync Sync) pullLink(ctx context.Context, remote, local string) error {
+	output, err := sync.AdbClient.ShellContext(ctx, sync.Serial, "readlink "+shellQuote(remote))
+	if err != nil {
+		return err
+	}
+	target := strings.TrimRight(output, "\r\n")
+	if target == "" {
+		return &AdbError{Service: "shell:readlink", Message: remote + ": not a symbolic link"}
+	}
+	if err := os.Remove(local); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Symlink(target, local)
+}
+
+// end region pull dir
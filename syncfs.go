@@ -0,0 +1,278 @@
+package adbutils
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// SyncFS
+// @Description: 以 root 为根目录的设备文件系统, 实现 fs.FS、fs.StatFS、fs.ReadDirFS 和 fs.ReadFileFS,
+// 可以直接用于 fs.WalkDir、template.ParseFS、http.FS 等. 所有请求都使用创建时传入的 ctx
+type SyncFS struct {
+	sync Sync
+	ctx  context.Context
+	root string
+}
+
+var (
+	_ fs.StatFS     = SyncFS{}
+	_ fs.ReadDirFS  = SyncFS{}
+	_ fs.ReadFileFS = SyncFS{}
+)
+
+// FS
+//
+//	@Description: 返回以 root 为根目录的 io/fs 文件系统, 如 device.Sync().FS(ctx, "/sdcard")
+//	@receiver sync
+//	@param ctx
+//	@param root 设备上的绝对路径
+//	@return SyncFS
+func (sync Sync) FS(ctx context.Context, root string) SyncFS {
+	return SyncFS{sync: sync, ctx: ctx, root: path.Clean(root)}
+}
+
+// remotePath 检查 name 是否为合法的 fs 路径并转换为设备上的路径
+func (fsys SyncFS) remotePath(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return path.Join(fsys.root, name), nil
+}
+
+func (fsys SyncFS) stat(op, name string) (*FileInfo, error) {
+	remote, err := fsys.remotePath(op, name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fsys.sync.StatContext(fsys.ctx, remote)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	// 文件不存在时 STAT 返回全零, 存在的文件 mode 中总有文件类型
+	if info.Mode == 0 {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return info, nil
+}
+
+// Stat 跟随符号链接, 设备不支持 stat_v2 时 STAT 不跟随符号链接
+func (fsys SyncFS) Stat(name string) (fs.FileInfo, error) {
+	info, err := fsys.stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return syncFileInfo{name: path.Base(name), info: *info}, nil
+}
+
+// Open 打开文件或目录, 文件内容在第一次 Read 时才开始传输
+func (fsys SyncFS) Open(name string) (fs.File, error) {
+	info, err := fsys.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	remote, _ := fsys.remotePath("open", name)
+	fi := syncFileInfo{name: path.Base(name), info: *info}
+	if fi.IsDir() {
+		return &syncDir{fsys: fsys, name: name, info: fi}, nil
+	}
+	return &syncFile{fsys: fsys, remote: remote, name: name, info: fi}, nil
+}
+
+// ReadDir 返回按名称排序的目录项, 目录项的信息来自 lstat, 不跟随符号链接
+func (fsys SyncFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	info, err := fsys.stat("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	remote, _ := fsys.remotePath("readdir", name)
+	infos, err := fsys.sync.IterDirectoryContext(fsys.ctx, remote)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	entries := make([]fs.DirEntry, 0, len(*infos))
+	for _, info := range *infos {
+		if info.Path == "." || info.Path == ".." {
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(syncFileInfo{name: info.Path, info: info}))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (fsys SyncFS) ReadFile(name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, _ := f.Stat()
+	if info.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: syscall.EISDIR}
+	}
+	var buf bytes.Buffer
+	buf.Grow(int(info.Size()))
+	if _, err := buf.ReadFrom(f); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// syncFile
+// @Description: SyncFS 打开的普通文件
+type syncFile struct {
+	fsys   SyncFS
+	remote string
+	name   string
+	info   syncFileInfo
+	r      io.ReadCloser
+	closed bool
+}
+
+func (f *syncFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *syncFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.r == nil {
+		r, err := f.fsys.sync.Open(f.fsys.ctx, f.remote)
+		if err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+		}
+		f.r = r
+	}
+	n, err := f.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	return n, err
+}
+
+func (f *syncFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	if f.r != nil {
+		return f.r.Close()
+	}
+	return nil
+}
+
+// syncDir
+// @Description: SyncFS 打开的目录, 第一次 ReadDir 时读取全部目录项
+type syncDir struct {
+	fsys    SyncFS
+	name    string
+	info    syncFileInfo
+	entries []fs.DirEntry
+	loaded  bool
+	closed  bool
+}
+
+func (d *syncDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *syncDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: syscall.EISDIR}
+}
+
+func (d *syncDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: fs.ErrClosed}
+	}
+	if !d.loaded {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.loaded = entries, true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+func (d *syncDir) Close() error {
+	if d.closed {
+		return &fs.PathError{Op: "close", Path: d.name, Err: fs.ErrClosed}
+	}
+	d.closed = true
+	return nil
+}
+
+// syncFileInfo
+// @Description: FileInfo 对应的 fs.FileInfo, Sys 返回 *FileInfo
+type syncFileInfo struct {
+	name string
+	info FileInfo
+}
+
+func (fi syncFileInfo) Name() string      { return fi.name }
+func (fi syncFileInfo) Size() int64       { return fi.info.Size }
+func (fi syncFileInfo) Mode() fs.FileMode { return fi.info.FileMode() }
+func (fi syncFileInfo) IsDir() bool       { return fi.Mode().IsDir() }
+func (fi syncFileInfo) Sys() interface{}  { return &fi.info }
+func (fi syncFileInfo) ModTime() time.Time {
+	if fi.info.Mtime == nil {
+		return time.Time{}
+	}
+	return *fi.info.Mtime
+}
+
+// FileMode
+//
+//	@Description: 把 unix mode 转换为 fs.FileMode, 包括文件类型和 setuid、setgid、sticky 位
+//	@receiver fileInfo
+//	@return fs.FileMode
+func (fileInfo FileInfo) FileMode() fs.FileMode {
+	mode := fs.FileMode(fileInfo.Mode & 0777)
+	switch fileInfo.Mode & syscall.S_IFMT {
+	case syscall.S_IFDIR:
+		mode |= fs.ModeDir
+	case syscall.S_IFLNK:
+		mode |= fs.ModeSymlink
+	case syscall.S_IFIFO:
+		mode |= fs.ModeNamedPipe
+	case syscall.S_IFSOCK:
+		mode |= fs.ModeSocket
+	case syscall.S_IFCHR:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case syscall.S_IFBLK:
+		mode |= fs.ModeDevice
+	}
+	if fileInfo.Mode&syscall.S_ISUID != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if fileInfo.Mode&syscall.S_ISGID != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if fileInfo.Mode&syscall.S_ISVTX != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
@@ -0,0 +1,256 @@
+package adbutils
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// Open
+//
+//	@Description: 打开远端文件用于流式读取, 内容直接从 sync 连接读出(压缩时为解压后的内容), 不经过内存或临时文件.
+//	文件不存在或没有权限时在这里返回错误, 读取完毕或中途放弃都需要 Close 释放连接
+//	@receiver sync
+//	@param ctx
+//	@param path
+//	@return io.ReadCloser
+//	@return error
+func (sync Sync) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	c, codec, err := sync.prepareRecv(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	data := &syncDataReader{sync: sync, c: c}
+	// 先读第一个包, 让 FAIL 在 Open 时就返回
+	if err = data.next(); err != nil && err != io.EOF {
+		c.Close()
+		return nil, err
+	}
+	f := &syncFileReader{c: c, r: data}
+	if codec != CompressionNone {
+		if f.decoder, err = codec.newReader(data); err != nil {
+			c.Close()
+			return nil, err
+		}
+		f.r = f.decoder
+	}
+	return f, nil
+}
+
+// Create
+//
+//	@Description: 创建或覆盖远端文件用于流式写入, 写入的数据按 64K 缓冲后以 DATA 包发送, 适合推送 tar 流、HTTP body 等生成的内容.
+//	Close 时发送 DONE 并等待设备确认, 写入是否成功以 Close 的返回值为准
+//	@receiver sync
+//	@param ctx
+//	@param path
+//	@param mode 文件权限, 如 0644
+//	@param mtime 写入后文件的修改时间, 零值时使用当前时间
+//	@return io.WriteCloser
+//	@return error
+func (sync Sync) Create(ctx context.Context, path string, mode int, mtime time.Time) (io.WriteCloser, error) {
+	return sync.create(ctx, path, syscall.S_IFREG|mode&07777, mtime)
+}
+
+// create 同 Create, mode 需要包含文件类型, 推送符号链接时使用 S_IFLNK
+func (sync Sync) create(ctx context.Context, path string, mode int, mtime time.Time) (*syncFileWriter, error) {
+	c, codec, err := sync.prepareSend(ctx, path, mode)
+	if err != nil {
+		return nil, err
+	}
+	if mtime.IsZero() {
+		mtime = time.Now()
+	}
+	buf := bufio.NewWriterSize(syncDataWriter{c}, syncMaxData)
+	f := &syncFileWriter{sync: sync, c: c, buf: buf, w: buf, mtime: mtime}
+	if codec != CompressionNone {
+		if f.encoder, err = codec.newWriter(buf); err != nil {
+			c.Close()
+			return nil, err
+		}
+		f.w = f.encoder
+	}
+	return f, nil
+}
+
+// syncFileReader
+// @Description: Open 返回的读取器
+type syncFileReader struct {
+	c       *AdbConnection
+	r       io.Reader
+	decoder io.ReadCloser
+	closed  bool
+}
+
+func (f *syncFileReader) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	return f.r.Read(p)
+}
+
+func (f *syncFileReader) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	if f.decoder != nil {
+		_ = f.decoder.Close()
+	}
+	// 没有读完时设备端还会继续发送, 只能直接关闭连接
+	f.c.Close()
+	return nil
+}
+
+// syncFileWriter
+// @Description: Create 返回的写入器, 压缩时写入顺序为 encoder -> 64K 缓冲 -> DATA 包
+type syncFileWriter struct {
+	sync    Sync
+	c       *AdbConnection
+	buf     *bufio.Writer
+	w       io.Writer
+	encoder io.WriteCloser
+	mtime   time.Time
+	err     error
+	closed  bool
+}
+
+func (f *syncFileWriter) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.err != nil {
+		return 0, f.err
+	}
+	n, err := f.w.Write(p)
+	if err != nil {
+		f.err = err
+	}
+	return n, err
+}
+
+func (f *syncFileWriter) Close() error {
+	if f.closed {
+		return f.err
+	}
+	f.closed = true
+	defer f.c.Close()
+	if f.err != nil {
+		return f.err
+	}
+	f.err = f.finish()
+	return f.err
+}
+
+// abort 放弃写入, 直接关闭连接, 设备端不会保留未完成的文件
+func (f *syncFileWriter) abort(err error) {
+	if !f.closed {
+		f.closed, f.err = true, err
+		f.c.Close()
+	}
+}
+
+// finish 写出剩余数据, 发送 DONE 并等待设备确认写入完成
+func (f *syncFileWriter) finish() error {
+	if f.encoder != nil {
+		if err := f.encoder.Close(); err != nil {
+			return err
+		}
+	}
+	if err := f.buf.Flush(); err != nil {
+		return err
+	}
+	if _, err := f.c.Conn.Write(syncRequest(DONE, uint32(f.mtime.Unix()))); err != nil {
+		return err
+	}
+	id, err := f.c.ReadString(4)
+	if err != nil {
+		return err
+	}
+	switch id {
+	case OKAY:
+		_, err = f.c.Read(4)
+		return err
+	case FAIL:
+		return f.sync.readSyncFail(f.c)
+	}
+	return fmt.Errorf("adb: unexpected sync response %q for SEND", id)
+}
+
+// syncDataWriter 把写入的数据拆成不超过 64K 的 DATA 包发送
+type syncDataWriter struct {
+	c *AdbConnection
+}
+
+func (w syncDataWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		size := len(p)
+		if size > syncMaxData {
+			size = syncMaxData
+		}
+		if _, err := w.c.Conn.Write(append(syncRequest(DATA, uint32(size)), p[:size]...)); err != nil {
+			return n, err
+		}
+		n += size
+		p = p[size:]
+	}
+	return n, nil
+}
+
+// syncDataReader 依次读取 RECV 回复中 DATA 包的内容, 收到 DONE 时返回 io.EOF, 收到 FAIL 时返回对应的错误
+type syncDataReader struct {
+	sync   Sync
+	c      *AdbConnection
+	remain int
+	err    error
+}
+
+// next 读取下一个包头, 返回 DONE/FAIL 对应的结束错误
+func (r *syncDataReader) next() error {
+	if r.err != nil {
+		return r.err
+	}
+	cmd, err := r.c.ReadString(4)
+	if err != nil {
+		return err
+	}
+	switch cmd {
+	case DATA:
+		res, err := r.sync.readUint32s(r.c, 1)
+		if err != nil {
+			return err
+		}
+		r.remain = int(res[0])
+	case DONE:
+		// DONE 之后还有 4 字节, 连接随后关闭, 不需要读取
+		r.err = io.EOF
+	case FAIL:
+		r.err = r.sync.readSyncFail(r.c)
+	default:
+		r.err = fmt.Errorf("adb: unexpected sync response %q for %s", cmd, r.c.service)
+	}
+	return r.err
+}
+
+func (r *syncDataReader) Read(p []byte) (int, error) {
+	for r.remain == 0 {
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	if len(p) > r.remain {
+		p = p[:r.remain]
+	}
+	n, err := r.c.Conn.Read(p)
+	r.remain -= n
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
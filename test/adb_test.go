@@ -1,28 +1,120 @@
 package test
 
 import (
+	"errors"
 	"testing"
-	"time"
 
 	"github.com/youluo1230/adbutils"
+	"github.com/youluo1230/adbutils/adbtest"
 )
 
-var adb = adbutils.AdbClient{Host: "localhost", Port: 5037, SocketTime: 10}
+func newServer(t *testing.T) *adbtest.Server {
+	t.Helper()
+	server, err := adbtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
 
 func TestServerVersion(t *testing.T) {
-	serverVersion, _ := adb.ServerVersion()
-	t.Logf("version: %d", serverVersion)
-	time.Sleep(time.Second * 1000)
+	server := newServer(t)
+	serverVersion, err := server.Client().ServerVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverVersion != 41 {
+		t.Fatalf("expect version 41, got %d", serverVersion)
+	}
 }
 
 func TestConnect(t *testing.T) {
-	//adb := adbutils.NewAdb("localhost", 5037, time.Second*100)
-	//println(adb.DeviceList()[0].Sync())
-	//println(adbutils.AdbPath())
-	//println(adb.Device(adbutils.SerialNTransportID{Serial: "a918b5a9"}).Sync().Stat("/data/local/tmp/output.txt").Mtime.String())
-	//pull, err := adb.Device(adbutils.SerialNTransportID{Serial: "a918b5a9"}).Sync().Pull("/sdcard/2232/aaa.apk", "bd.apk")
-	//if err != nil {
-	//	println(err.Error())
-	//}
-	//println(pull)
+	server := newServer(t)
+	adb := server.Client()
+	output, err := adb.Connect("127.0.0.1:5555")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "connected to 127.0.0.1:5555" {
+		t.Fatalf("unexpected output %q", output)
+	}
+	hello, err := adb.Device(adbutils.SerialNTransportID{Serial: "127.0.0.1:5555"}).SayHello()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hello != "hello from 127.0.0.1:5555\n" {
+		t.Fatalf("unexpected hello %q", hello)
+	}
+	if err := adb.Disconnect("127.0.0.1:5555", true); err != nil {
+		t.Fatal(err)
+	}
+	var adbErr *adbutils.AdbError
+	if err := adb.Disconnect("127.0.0.1:5555", true); !errors.As(err, &adbErr) {
+		t.Fatalf("expect AdbError, got %v", err)
+	}
+	if err := adb.Disconnect("127.0.0.1:5555", false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeviceList(t *testing.T) {
+	server := newServer(t)
+	server.AddDevice("emulator-5554")
+	server.AddDevice("a918b5a9")
+	server.AddDevice("offline01").SetState("offline")
+	devices, err := server.Client().DeviceList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 2 || devices[0].Serial != "emulator-5554" || devices[1].TransportID != 2 {
+		t.Fatalf("unexpected devices %+v", devices)
+	}
+}
+
+func TestDeviceListFields(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("a918b5a9")
+	d.TransportID = 12
+	d.Model = "Pixel_7"
+	d.DeviceName = "panther"
+	devices, err := server.Client().DeviceList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// usb:1-1 位于状态和 product 之间, transport_id 有两位
+	if len(devices) != 1 || devices[0].TransportID != 12 || devices[0].Model != "Pixel_7" || devices[0].DeviceType != "panther" {
+		t.Fatalf("unexpected devices %+v", devices)
+	}
+}
+
+func TestShell(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	d.SetShellOutput("pm list packages", "package:com.android.settings\npackage:com.example\n")
+	device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+	packages, err := device.ListPackages(adbutils.ListPackagesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packages) != 2 || packages[1].Name != "com.example" {
+		t.Fatalf("unexpected packages %v", packages)
+	}
+	sdk, err := device.GetProp("ro.build.version.sdk")
+	if err != nil || sdk != "30" {
+		t.Fatalf("unexpected sdk %q %v", sdk, err)
+	}
+	features, err := device.GetFeatures()
+	if err != nil || features != "cmd,shell_v2,stat_v2,ls_v2,sendrecv_v2" {
+		t.Fatalf("unexpected features %q %v", features, err)
+	}
+
+	d.SetState("unauthorized")
+	if _, err := device.Shell("ls", 0); !errors.Is(err, adbutils.ErrUnauthorized) {
+		t.Fatalf("expect unauthorized, got %v", err)
+	}
+	_, err = server.Client().Device(adbutils.SerialNTransportID{Serial: "missing"}).Shell("ls", 0)
+	if !errors.Is(err, adbutils.ErrDeviceNotFound) {
+		t.Fatalf("expect device not found, got %v", err)
+	}
 }
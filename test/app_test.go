@@ -0,0 +1,70 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/youluo1230/adbutils"
+)
+
+func TestCurrentApp(t *testing.T) {
+	cases := []struct {
+		name       string
+		window     string
+		activities string
+		expect     adbutils.RunningAppInfo
+	}{
+		{
+			name: "android5",
+			window: `  mCurrentFocus=Window{2f5c7a3 u0 com.android.settings/com.android.settings.Settings}
+  mFocusedApp=AppWindowToken{1e3a4b2 token=Token{3c5d6e7 ActivityRecord{8f9a0b1 u0 com.android.settings/.Settings t5}}}`,
+			expect: adbutils.RunningAppInfo{Package: "com.android.settings", Activity: "com.android.settings.Settings", Pid: 1234},
+		},
+		{
+			name: "android13 popup",
+			window: `  mFocusedApp=ActivityRecord{d3a1b3c u0 com.example.app/.MainActivity t12}
+  mCurrentFocus=Window{7e8f9a0 u0 PopupWindow:5b6c7d8}`,
+			expect: adbutils.RunningAppInfo{Package: "com.example.app", Activity: ".MainActivity", Pid: 1234},
+		},
+		{
+			name:   "android10 fallback",
+			window: "  mCurrentFocus=null\n  mFocusedApp=null\n",
+			activities: `  ResumedActivity: ActivityRecord{a1b2c3d u10 com.example.app/com.example.app.ui.Main$Inner t7}
+    topResumedActivity=ActivityRecord{a1b2c3d u10 com.example.app/com.example.app.ui.Main$Inner t7}`,
+			expect: adbutils.RunningAppInfo{Package: "com.example.app", Activity: "com.example.app.ui.Main$Inner", Pid: 1234},
+		},
+		{
+			name:       "android9 fallback",
+			window:     "  mCurrentFocus=Window{4c5d6e7 u0 StatusBar}\n",
+			activities: "    mResumedActivity: ActivityRecord{9a8b7c6 u0 com.example.app/.SplashActivity t3}\n",
+			expect:     adbutils.RunningAppInfo{Package: "com.example.app", Activity: ".SplashActivity", Pid: 1234},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := newServer(t)
+			d := server.AddDevice("emulator-5554")
+			d.SetShellOutput("dumpsys window", c.window)
+			d.SetShellOutput("dumpsys activity activities", c.activities)
+			d.SetShellOutput("pidof '"+c.expect.Package+"'", "1234\n")
+			device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+			info, err := device.CurrentApp(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *info != c.expect {
+				t.Fatalf("unexpected app %+v", info)
+			}
+		})
+	}
+
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	d.SetShellOutput("dumpsys window", "  mCurrentFocus=Window{4c5d6e7 u0 NotificationShade}\n")
+	d.SetShellOutput("dumpsys activity activities", "")
+	device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+	if _, err := device.CurrentApp(context.Background()); !errors.Is(err, adbutils.ErrNoCurrentApp) {
+		t.Fatalf("expect no current app, got %v", err)
+	}
+}
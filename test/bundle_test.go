@@ -0,0 +1,225 @@
+package test
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/youluo1230/adbutils"
+	"github.com/youluo1230/adbutils/adbtest"
+)
+
+func splitApks(t *testing.T, pkg string, splits ...string) []string {
+	t.Helper()
+	apks := []string{writeApk(t, adbtest.ApkManifest{Package: pkg, VersionCode: 1})}
+	for _, split := range splits {
+		apks = append(apks, writeApk(t, adbtest.ApkManifest{Package: pkg, VersionCode: 1, Split: split}))
+	}
+	return apks
+}
+
+func TestInstallMultiple(t *testing.T) {
+	cases := map[string][]string{
+		"abb_exec": {"cmd", "abb_exec", "shell_v2", "stat_v2", "sendrecv_v2"},
+		"cmd":      {"cmd", "shell_v2", "stat_v2", "sendrecv_v2"},
+		"push":     {"shell_v2", "stat_v2", "sendrecv_v2"},
+	}
+	for name, features := range cases {
+		t.Run(name, func(t *testing.T) {
+			server := newServer(t)
+			d := server.AddDevice("emulator-5554")
+			d.SetFeatures(features...)
+			device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+			ctx := context.Background()
+
+			apks := splitApks(t, "com.example.split", "config.arm64_v8a", "config.xxhdpi")
+			if err := device.InstallMultiple(ctx, apks, adbutils.InstallOptions{Replace: true}); err != nil {
+				t.Fatal(err)
+			}
+			packages := d.Packages()
+			if len(packages) != 1 || !reflect.DeepEqual(packages[0].Splits, []string{"config.arm64_v8a", "config.xxhdpi"}) {
+				t.Fatalf("unexpected packages %+v", packages)
+			}
+			if pushed := d.SyncDataPackets() > 0; pushed != (name == "push") {
+				t.Fatalf("unexpected sync usage %v", pushed)
+			}
+			if names, _ := d.FS.List("/data/local/tmp"); len(names) != 0 {
+				t.Fatalf("temp apk not removed %v", names)
+			}
+
+			// 包名不一致时提交失败, 会话被放弃
+			other := writeApk(t, adbtest.ApkManifest{Package: "com.example.other", VersionCode: 1, Split: "config.en"})
+			err := device.InstallMultiple(ctx, append(apks, other), adbutils.InstallOptions{Replace: true})
+			var installErr *adbutils.InstallError
+			if !errors.As(err, &installErr) || installErr.Code != "INSTALL_FAILED_INVALID_APK" {
+				t.Fatalf("expect invalid apk error, got %v", err)
+			}
+			if n := d.InstallSessions(); n != 0 {
+				t.Fatalf("expect session abandoned, %d left", n)
+			}
+		})
+	}
+}
+
+func TestInstallMultipleAbandon(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+	d.OnShellPrefix("pm install-commit ", func(req adbtest.ShellRequest) adbtest.ShellResponse {
+		return adbtest.ShellResponse{Stdout: "Failure [INSTALL_FAILED_INSUFFICIENT_STORAGE: not enough space]\n"}
+	})
+	var abandoned string
+	d.OnShellPrefix("pm install-abandon ", func(req adbtest.ShellRequest) adbtest.ShellResponse {
+		abandoned = req.Command
+		return adbtest.ShellResponse{Stdout: "Success\n"}
+	})
+	err := device.InstallMultiple(context.Background(), splitApks(t, "com.example.split", "config.en"), adbutils.InstallOptions{})
+	if !errors.Is(err, adbutils.ErrInstallInsufficientStorage) {
+		t.Fatalf("expect insufficient storage, got %v", err)
+	}
+	if abandoned != "pm install-abandon 1" {
+		t.Fatalf("unexpected abandon command %q", abandoned)
+	}
+	if len(d.Packages()) != 0 {
+		t.Fatalf("unexpected packages %+v", d.Packages())
+	}
+}
+
+func TestSelectSplits(t *testing.T) {
+	apks := splitApks(t, "com.example.split",
+		"config.armeabi_v7a", "config.arm64_v8a", "config.x86_64",
+		"config.mdpi", "config.xhdpi", "config.xxhdpi", "config.xxxhdpi",
+		"config.en", "config.zh", "feature", "feature.config.hdpi")
+	names := func(selected []string) []string {
+		var result []string
+		for _, apk := range selected {
+			info, err := adbutils.ParseApk(apk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result = append(result, info.Split)
+		}
+		return result
+	}
+	cases := []struct {
+		spec   adbutils.DeviceSpec
+		expect []string
+	}{
+		{
+			adbutils.DeviceSpec{ABIs: []string{"arm64-v8a", "armeabi-v7a"}, Density: 440, Locales: []string{"zh-CN"}},
+			[]string{"", "config.arm64_v8a", "config.xxhdpi", "config.zh", "feature", "feature.config.hdpi"},
+		},
+		{
+			adbutils.DeviceSpec{ABIs: []string{"armeabi-v7a"}, Density: 800, Locales: []string{"", "-", "fr-FR"}},
+			[]string{"", "config.armeabi_v7a", "config.xxxhdpi", "feature", "feature.config.hdpi"},
+		},
+	}
+	for _, c := range cases {
+		selected, err := adbutils.SelectSplits(apks, c.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]bool{}
+		for _, name := range names(selected) {
+			got[name] = true
+		}
+		for _, name := range c.expect {
+			if !got[name] {
+				t.Fatalf("%+v: expect %q in %v", c.spec, name, names(selected))
+			}
+		}
+		if len(got) != len(c.expect) {
+			t.Fatalf("%+v: unexpected splits %v", c.spec, names(selected))
+		}
+	}
+	if _, err := adbutils.SelectSplits(apks[1:], adbutils.DeviceSpec{}); err == nil {
+		t.Fatal("expect error without base apk")
+	}
+}
+
+func writeBundle(t *testing.T, name string, files map[string][]byte) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestInstallBundle(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	d.SetProp("ro.product.cpu.abilist", "x86_64,x86")
+	d.SetProp("ro.sf.lcd_density", "320")
+	d.SetProp("persist.sys.locale", "en-US")
+	device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+	ctx := context.Background()
+
+	spec, err := device.DeviceSpec(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(spec, adbutils.DeviceSpec{ABIs: []string{"x86_64", "x86"}, Density: 320, Locales: []string{"en-US"}}) {
+		t.Fatalf("unexpected device spec %+v", spec)
+	}
+
+	apk := func(split string) []byte {
+		return adbtest.BuildApk(adbtest.ApkManifest{Package: "com.example.bundle", VersionCode: 5, Split: split})
+	}
+	apks := writeBundle(t, "app.apks", map[string][]byte{
+		"toc.pb":                         []byte("toc"),
+		"splits/base-master.apk":         apk(""),
+		"splits/base-x86_64.apk":         apk("config.x86_64"),
+		"splits/base-arm64_v8a.apk":      apk("config.arm64_v8a"),
+		"splits/base-xhdpi.apk":          apk("config.xhdpi"),
+		"splits/base-xxhdpi.apk":         apk("config.xxhdpi"),
+		"splits/base-en.apk":             apk("config.en"),
+		"splits/base-de.apk":             apk("config.de"),
+		"standalones/standalone-x86.apk": apk(""),
+	})
+	if err := device.InstallBundle(ctx, apks, adbutils.InstallOptions{Replace: true}); err != nil {
+		t.Fatal(err)
+	}
+	if packages := d.Packages(); len(packages) != 1 || !reflect.DeepEqual(packages[0].Splits, []string{"config.en", "config.x86_64", "config.xhdpi"}) {
+		t.Fatalf("unexpected packages %+v", packages)
+	}
+
+	obb := []byte(strings.Repeat("obb", 100))
+	xapk := writeBundle(t, "app.xapk", map[string][]byte{
+		"com.example.bundle.apk": apk(""),
+		"config.x86_64.apk":      apk("config.x86_64"),
+		"icon.png":               []byte("png"),
+		"Android/obb/com.example.bundle/main.5.com.example.bundle.obb": obb,
+		"manifest.json": []byte(`{"package_name":"com.example.bundle","expansions":[{"file":"Android/obb/com.example.bundle/main.5.com.example.bundle.obb",` +
+			`"install_location":"EXTERNAL_STORAGE","install_path":"Android/obb/com.example.bundle/main.5.com.example.bundle.obb"}]}`),
+	})
+	if err := device.InstallBundle(ctx, xapk, adbutils.InstallOptions{Replace: true}); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := d.FS.ReadFile("/sdcard/Android/obb/com.example.bundle/main.5.com.example.bundle.obb"); !ok || string(data) != string(obb) {
+		t.Fatal("obb not pushed")
+	}
+	if packages := d.Packages(); len(packages) != 1 || !reflect.DeepEqual(packages[0].Splits, []string{"config.x86_64"}) {
+		t.Fatalf("unexpected packages %+v", packages)
+	}
+}
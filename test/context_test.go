@@ -0,0 +1,65 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/youluo1230/adbutils"
+)
+
+// 服务端只接收连接不回复, 用来验证 ctx 能打断阻塞读
+func silentServer(t *testing.T) *net.TCPAddr {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	return l.Addr().(*net.TCPAddr)
+}
+
+func TestServerVersionContextCancel(t *testing.T) {
+	addr := silentServer(t)
+	client := adbutils.AdbClient{Host: "127.0.0.1", Port: addr.Port, SocketTime: 10 * time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.ServerVersionContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("cancel took too long: %v", time.Since(start))
+	}
+}
+
+func TestShellContextCancel(t *testing.T) {
+	addr := silentServer(t)
+	client := adbutils.NewAdb("127.0.0.1", addr.Port, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	_, err := client.ShellContext(ctx, "serial", "sleep 10")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expect context canceled, got %v", err)
+	}
+}
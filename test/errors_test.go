@@ -0,0 +1,60 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/youluo1230/adbutils"
+)
+
+// 对任意请求都返回 FAIL 的服务端
+func failServer(t *testing.T, message string) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 1024)
+				if _, err := conn.Read(buf); err != nil {
+					return
+				}
+				fmt.Fprintf(conn, "FAIL%04x%s", len(message), message)
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestAdbErrorDecode(t *testing.T) {
+	cases := []struct {
+		message string
+		target  error
+	}{
+		{"device 'a918b5a9' not found", adbutils.ErrDeviceNotFound},
+		{"no devices/emulators found", adbutils.ErrDeviceNotFound},
+		{"device offline", adbutils.ErrDeviceOffline},
+		{"device unauthorized.\nThis adb server's $ADB_VENDOR_KEYS is not set", adbutils.ErrUnauthorized},
+	}
+	for _, c := range cases {
+		client := adbutils.AdbClient{Host: "127.0.0.1", Port: failServer(t, c.message), SocketTime: time.Second}
+		_, err := client.Device(adbutils.SerialNTransportID{Serial: "a918b5a9"}).Shell("ls", time.Second)
+		if !errors.Is(err, c.target) {
+			t.Errorf("%q: expect %v, got %v", c.message, c.target, err)
+		}
+		var adbErr *adbutils.AdbError
+		if !errors.As(err, &adbErr) || adbErr.Message != c.message || adbErr.Service != "host:transport:a918b5a9" {
+			t.Errorf("%q: unexpected error %#v", c.message, err)
+		}
+	}
+}
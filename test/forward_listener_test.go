@@ -0,0 +1,81 @@
+package test
+
+import (
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/youluo1230/adbutils"
+)
+
+func TestListenForward(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	d.HandleService("localabstract:echo", func(service string, conn net.Conn) {
+		io.Copy(conn, conn)
+	})
+	device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+
+	for _, local := range []string{"127.0.0.1:0", "unix:" + filepath.Join(t.TempDir(), "echo.sock")} {
+		fl, err := device.ListenForward(context.Background(), local, adbutils.LOCALABSTRACT, "echo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := net.Dial(fl.Addr().Network(), fl.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(conn, "hello")
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+			t.Fatalf("unexpected echo %q %v", buf, err)
+		}
+		stats := fl.Stats()
+		if len(stats) != 1 || stats[0].BytesSent != 5 || stats[0].BytesRecv != 5 || !stats[0].End.IsZero() {
+			t.Fatalf("unexpected stats %+v", stats)
+		}
+
+		// Close 会断开活动连接并停止监听
+		fl.Close()
+		if _, err := conn.Read(buf); err == nil {
+			t.Fatal("expect connection closed")
+		}
+		conn.Close()
+		if _, err := net.Dial(fl.Addr().Network(), fl.Addr().String()); err == nil {
+			t.Fatal("expect listener closed")
+		}
+		stats = fl.Stats()
+		if len(stats) != 1 || stats[0].End.IsZero() || stats[0].Err != nil {
+			t.Fatalf("unexpected stats after close %+v", stats)
+		}
+	}
+	if len(server.Forwards()) != 0 {
+		t.Fatalf("expect forward table untouched, got %v", server.Forwards())
+	}
+}
+
+func TestListenForwardDialError(t *testing.T) {
+	server := newServer(t)
+	server.AddDevice("emulator-5554")
+	device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+	fl, err := device.ListenForward(context.Background(), "127.0.0.1:0", adbutils.TCP, "8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fl.Close()
+	conn, err := net.Dial("tcp", fl.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// 设备上没有 tcp:8080 服务, 本地连接会被关闭
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expect EOF, got %v", err)
+	}
+	fl.Close()
+	if stats := fl.Stats(); len(stats) != 1 || stats[0].Err == nil {
+		t.Fatalf("expect dial error in stats, got %+v", stats)
+	}
+}
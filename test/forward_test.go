@@ -0,0 +1,72 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/youluo1230/adbutils"
+)
+
+func TestForward(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	d.HandleService("tcp:", func(service string, conn net.Conn) {
+		io.Copy(conn, conn)
+	})
+	server.AddDevice("emulator-5556")
+	device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+	other := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5556"})
+
+	port, err := device.ForwardPort(8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := device.ForwardPort("tcp:8080"); err != nil || again != port {
+		t.Fatalf("expect reuse port %d, got %d %v", port, again, err)
+	}
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(conn, "ping")
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("unexpected echo %q %v", buf, err)
+	}
+	conn.Close()
+
+	if _, err := other.ForwardPort("localabstract:agent"); err != nil {
+		t.Fatal(err)
+	}
+	items, err := device.ForwardList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := fmt.Sprintf("tcp:%d", port)
+	if len(items) != 1 || items[0] != (adbutils.ForwardItem{Serial: "emulator-5554", Local: local, Remote: "tcp:8080"}) {
+		t.Fatalf("unexpected forward list %v", items)
+	}
+	var adbErr *adbutils.AdbError
+	if err := other.ForWard(local, "tcp:9000", true); !errors.As(err, &adbErr) {
+		t.Fatalf("expect norebind error, got %v", err)
+	}
+
+	if err := device.ForwardRemove(local); err != nil {
+		t.Fatal(err)
+	}
+	if err := device.ForwardRemove(local); !errors.As(err, &adbErr) {
+		t.Fatalf("expect not found error, got %v", err)
+	}
+	if len(server.Forwards()) != 1 {
+		t.Fatalf("unexpected forwards %v", server.Forwards())
+	}
+	if err := device.ForwardRemoveAll(); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.Forwards()) != 0 {
+		t.Fatalf("expect no forwards, got %v", server.Forwards())
+	}
+}
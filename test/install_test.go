@@ -0,0 +1,183 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/youluo1230/adbutils"
+	"github.com/youluo1230/adbutils/adbtest"
+)
+
+func TestParseApk(t *testing.T) {
+	data := adbtest.BuildApk(adbtest.ApkManifest{Package: "com.example.app", VersionCode: 42, VersionName: "1.2.3", Split: "config.arm64_v8a"})
+	info, err := adbutils.ParseApkReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := adbutils.ApkInfo{Package: "com.example.app", VersionCode: 42, VersionName: "1.2.3", Split: "config.arm64_v8a"}
+	if *info != expect {
+		t.Fatalf("unexpected apk info %+v", info)
+	}
+	if _, err := adbutils.ParseApkReader(bytes.NewReader([]byte("not a zip")), 9); err == nil {
+		t.Fatal("expect error parsing invalid apk")
+	}
+}
+
+func writeApk(t *testing.T, m adbtest.ApkManifest) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), m.Package+".apk")
+	if err := os.WriteFile(p, adbtest.BuildApk(m), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestInstall(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+	ctx := context.Background()
+
+	v2 := writeApk(t, adbtest.ApkManifest{Package: "com.example.app", VersionCode: 2, VersionName: "2.0"})
+	if err := device.Install(ctx, v2, adbutils.InstallOptions{Replace: true, Verify: adbutils.ChecksumSHA256}); err != nil {
+		t.Fatal(err)
+	}
+	if packages := d.Packages(); len(packages) != 1 || packages[0].Name != "com.example.app" || packages[0].VersionCode != 2 {
+		t.Fatalf("unexpected packages %+v", packages)
+	}
+	if names, _ := d.FS.List("/data/local/tmp"); len(names) != 0 {
+		t.Fatalf("temp apk not removed %v", names)
+	}
+
+	// 降级
+	v1 := writeApk(t, adbtest.ApkManifest{Package: "com.example.app", VersionCode: 1, VersionName: "1.0"})
+	err := device.Install(ctx, v1, adbutils.InstallOptions{Replace: true})
+	var installErr *adbutils.InstallError
+	if !errors.Is(err, adbutils.ErrInstallVersionDowngrade) || !errors.As(err, &installErr) || installErr.Code != "INSTALL_FAILED_VERSION_DOWNGRADE" {
+		t.Fatalf("expect downgrade error, got %v", err)
+	}
+	if err := device.Install(ctx, v1, adbutils.InstallOptions{Replace: true, Downgrade: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 从 url 下载并启动
+	data := adbtest.BuildApk(adbtest.ApkManifest{Package: "com.example.web", VersionCode: 1})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/app.apk" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	defer ts.Close()
+	var launched string
+	d.OnShellPrefix("monkey ", func(req adbtest.ShellRequest) adbtest.ShellResponse {
+		launched = req.Command
+		return adbtest.ShellResponse{Stdout: "Events injected: 1\n"}
+	})
+	if err := device.Install(ctx, ts.URL+"/app.apk", adbutils.InstallOptions{Launch: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(launched, "-p 'com.example.web'") {
+		t.Fatalf("unexpected launch command %q", launched)
+	}
+	if err := device.Install(ctx, ts.URL+"/missing.apk", adbutils.InstallOptions{}); err == nil {
+		t.Fatal("expect download error")
+	}
+}
+
+func TestInstallSignatureMismatch(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	d.AddPackage(adbtest.Package{Name: "com.example.app", VersionCode: 1})
+	// 不支持流式安装的旧设备, 通过 pm install 安装
+	d.SetFeatures("shell_v2", "stat_v2", "ls_v2", "sendrecv_v2")
+	device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+	apk := writeApk(t, adbtest.ApkManifest{Package: "com.example.app", VersionCode: 1})
+
+	attempts := 0
+	d.OnShellPrefix("pm install ", func(req adbtest.ShellRequest) adbtest.ShellResponse {
+		attempts++
+		if len(d.Packages()) > 0 {
+			return adbtest.ShellResponse{Stdout: "Failure [INSTALL_FAILED_UPDATE_INCOMPATIBLE: Package com.example.app signatures do not match previously installed version; ignoring!]\n"}
+		}
+		return adbtest.ShellResponse{Stdout: "Success\n"}
+	})
+	err := device.Install(context.Background(), apk, adbutils.InstallOptions{Replace: true})
+	if !errors.Is(err, adbutils.ErrInstallSignatureMismatch) {
+		t.Fatalf("expect signature mismatch, got %v", err)
+	}
+	if err := device.Install(context.Background(), apk, adbutils.InstallOptions{Replace: true, UninstallOnSignatureMismatch: true}); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 {
+		t.Fatalf("expect 3 install attempts, got %d", attempts)
+	}
+
+	// 不是 Failure 格式的输出
+	d.OnShell("pm install -r -t '/data/local/tmp/app.apk'", func(req adbtest.ShellRequest) adbtest.ShellResponse {
+		return adbtest.ShellResponse{Stdout: "Error: java.lang.SecurityException: denied\n"}
+	})
+	var installErr *adbutils.InstallError
+	if err := device.InstallRemote("/data/local/tmp/app.apk", false); !errors.As(err, &installErr) || installErr.Code != "" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestInstallStream(t *testing.T) {
+	cases := map[string][]string{
+		"abb_exec": {"cmd", "abb_exec", "shell_v2", "stat_v2", "sendrecv_v2"},
+		"cmd":      {"cmd", "shell_v2", "stat_v2", "sendrecv_v2"},
+		"push":     {"shell_v2", "stat_v2", "sendrecv_v2"},
+	}
+	for name, features := range cases {
+		t.Run(name, func(t *testing.T) {
+			server := newServer(t)
+			d := server.AddDevice("emulator-5554")
+			d.SetFeatures(features...)
+			device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+			data := adbtest.BuildApk(adbtest.ApkManifest{Package: "com.example.stream", VersionCode: 3})
+
+			err := device.InstallStream(context.Background(), bytes.NewReader(data), int64(len(data)), adbutils.InstallOptions{Replace: true, GrantAll: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if packages := d.Packages(); len(packages) != 1 || packages[0].Name != "com.example.stream" {
+				t.Fatalf("unexpected packages %+v", packages)
+			}
+			// 流式安装不经过 sync
+			if pushed := d.SyncDataPackets() > 0; pushed != (name == "push") {
+				t.Fatalf("unexpected sync usage %v", pushed)
+			}
+			if names, _ := d.FS.List("/data/local/tmp"); len(names) != 0 {
+				t.Fatalf("temp apk not removed %v", names)
+			}
+
+			err = device.InstallStream(context.Background(), strings.NewReader("not an apk"), 10, adbutils.InstallOptions{})
+			if !errors.As(err, new(*adbutils.InstallError)) {
+				t.Fatalf("expect install error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestInstallStreamUnknownSize(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+	data := adbtest.BuildApk(adbtest.ApkManifest{Package: "com.example.stream", VersionCode: 1})
+	// 大小未知时只能先推送
+	if err := device.InstallStream(context.Background(), bytes.NewReader(data), -1, adbutils.InstallOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Packages()) != 1 || d.SyncDataPackets() == 0 {
+		t.Fatalf("unexpected packages %+v", d.Packages())
+	}
+}
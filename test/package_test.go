@@ -0,0 +1,191 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/youluo1230/adbutils"
+	"github.com/youluo1230/adbutils/adbtest"
+)
+
+// Android 13 模拟器上 dumpsys package 的输出, 省略了部分内容
+const dumpsysPackage = `Activity Resolver Table:
+  Non-Data Actions:
+      android.intent.action.MAIN:
+        9d1c2a1 com.example.app/.MainActivity filter 3c0e5b4
+
+Permissions:
+  Permission [com.example.app.DYNAMIC_RECEIVER_NOT_EXPORTED_PERMISSION] (8f5e0c6):
+    sourcePackage=com.example.app
+
+Key Set Manager:
+  [com.example.app]
+      Signing KeySets: 42
+
+Packages:
+  Package [com.example.app] (5a0b2f7):
+    userId=10153
+    pkg=Package{1e9d364 com.example.app}
+    codePath=/data/app/~~Hk3A==/com.example.app-Zx9Q==
+    resourcePath=/data/app/~~Hk3A==/com.example.app-Zx9Q==
+    primaryCpuAbi=null
+    versionCode=1024 minSdk=21 targetSdk=33
+    minExtensionVersions=[]
+    versionName=2.5.0 beta
+    splits=[base]
+    apkSigningVersion=2
+    flags=[ HAS_CODE ALLOW_CLEAR_USER_DATA ALLOW_BACKUP ]
+    timeStamp=2023-05-06 07:08:09
+    firstInstallTime=2023-05-01 10:20:30
+    lastUpdateTime=2023-05-06 07:08:10
+    installerPackageName=com.android.vending
+    signatures=PackageSignatures{9c4a2b1 version:2, signatures:[6d9e31ea], past signatures:[]}
+    installPermissionsFixed=true
+    pkgFlags=[ HAS_CODE ALLOW_CLEAR_USER_DATA ALLOW_BACKUP ]
+    requested permissions:
+      android.permission.INTERNET
+      android.permission.CAMERA
+      android.permission.READ_SMS: restricted=true
+    install permissions:
+      android.permission.INTERNET: granted=true
+    User 0: ceDataInode=131090 installed=true hidden=false suspended=false distractionFlags=0 stopped=false notLaunched=false enabled=0 instant=false virtual=false
+      gids=[3003]
+      runtime permissions:
+        android.permission.CAMERA: granted=true, flags=[ USER_SET|USER_SENSITIVE_WHEN_GRANTED ]
+        android.permission.READ_SMS: granted=false, flags=[ RESTRICTION_INSTALLER_EXEMPT ]
+    User 10: ceDataInode=0 installed=true hidden=false suspended=false distractionFlags=0 stopped=true notLaunched=true enabled=3 instant=false virtual=false
+      runtime permissions:
+        android.permission.CAMERA: granted=false, flags=[ ]
+
+Hidden system packages:
+  Package [com.example.app] (1b2c3d4):
+    versionCode=1 minSdk=21 targetSdk=33
+    versionName=1.0
+
+Queries:
+  system apps queryable: false
+`
+
+func TestPackageInfo(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+	d.OnShell("dumpsys package 'com.example.app'", func(req adbtest.ShellRequest) adbtest.ShellResponse {
+		return adbtest.ShellResponse{Stdout: dumpsysPackage}
+	})
+	d.OnShell("dumpsys package 'com.example.missing'", func(req adbtest.ShellRequest) adbtest.ShellResponse {
+		return adbtest.ShellResponse{Stdout: "Unable to find package: com.example.missing\n"}
+	})
+
+	info, err := device.PackageInfo(context.Background(), "com.example.app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := &adbutils.PackageInfo{
+		Name:                 "com.example.app",
+		VersionName:          "2.5.0 beta",
+		VersionCode:          1024,
+		MinSdk:               21,
+		TargetSdk:            33,
+		CodePath:             "/data/app/~~Hk3A==/com.example.app-Zx9Q==",
+		Installer:            "com.android.vending",
+		FirstInstallTime:     time.Date(2023, 5, 1, 10, 20, 30, 0, time.Local),
+		LastUpdateTime:       time.Date(2023, 5, 6, 7, 8, 10, 0, time.Local),
+		Signatures:           []string{"6d9e31ea"},
+		Enabled:              true,
+		RequestedPermissions: []string{"android.permission.INTERNET", "android.permission.CAMERA", "android.permission.READ_SMS"},
+		InstallPermissions:   []adbutils.PermissionState{{Name: "android.permission.INTERNET", Granted: true}},
+		RuntimePermissions: []adbutils.PermissionState{
+			{Name: "android.permission.CAMERA", Granted: true, Flags: []string{"USER_SET", "USER_SENSITIVE_WHEN_GRANTED"}},
+			{Name: "android.permission.READ_SMS", Flags: []string{"RESTRICTION_INSTALLER_EXEMPT"}},
+		},
+	}
+	if !reflect.DeepEqual(info, expect) {
+		t.Fatalf("unexpected package info\n%+v\nexpect\n%+v", info, expect)
+	}
+	if granted := info.GrantedPermissions(); !reflect.DeepEqual(granted, []string{"android.permission.INTERNET", "android.permission.CAMERA"}) {
+		t.Fatalf("unexpected granted permissions %v", granted)
+	}
+
+	if _, err := device.PackageInfo(context.Background(), "com.example.missing"); !errors.Is(err, adbutils.ErrPackageNotFound) {
+		t.Fatalf("expect package not found, got %v", err)
+	}
+}
+
+func TestListPackages(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	d.AddPackage(adbtest.Package{Name: "com.android.settings", VersionCode: 33, System: true, UID: 1000})
+	d.AddPackage(adbtest.Package{Name: "com.example.app", VersionCode: 12, Installer: "com.android.vending", UID: 10153,
+		Path: "/data/app/~~Hk3A==/com.example.app-Zx9Q==/base.apk"})
+	d.AddPackage(adbtest.Package{Name: "com.example.disabled", VersionCode: 1, Disabled: true, UID: 10154})
+	device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+	ctx := context.Background()
+
+	items, err := device.ListPackagesContext(ctx, adbutils.ListPackagesOptions{ThirdParty: true, Enabled: true, Path: true, Installer: true, VersionCode: true, UID: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []adbutils.PackageItem{{
+		Name:        "com.example.app",
+		Path:        "/data/app/~~Hk3A==/com.example.app-Zx9Q==/base.apk",
+		Installer:   "com.android.vending",
+		VersionCode: 12,
+		UID:         10153,
+	}}
+	if !reflect.DeepEqual(items, expect) {
+		t.Fatalf("unexpected packages %+v", items)
+	}
+
+	items, err = device.ListPackagesContext(ctx, adbutils.ListPackagesOptions{System: true, User: "0", Filter: "settings"})
+	if err != nil || !reflect.DeepEqual(items, []adbutils.PackageItem{{Name: "com.android.settings"}}) {
+		t.Fatalf("unexpected packages %+v %v", items, err)
+	}
+	items, err = device.ListPackagesContext(ctx, adbutils.ListPackagesOptions{Disabled: true, Installer: true})
+	if err != nil || !reflect.DeepEqual(items, []adbutils.PackageItem{{Name: "com.example.disabled"}}) {
+		t.Fatalf("unexpected packages %+v %v", items, err)
+	}
+	if items, err = device.ListPackages(adbutils.ListPackagesOptions{}); err != nil || len(items) != 3 {
+		t.Fatalf("unexpected packages %+v %v", items, err)
+	}
+}
+
+// Android 6.0 上 dumpsys package 的输出, 没有 minSdk, 签名格式不同
+const dumpsysPackageM = `Packages:
+  Package [com.example.old] (3e1f2a9):
+    userId=10061
+    pkg=Package{1c2d3e4 com.example.old}
+    codePath=/data/app/com.example.old-1
+    versionCode=7 targetSdk=23
+    versionName=0.7
+    firstInstallTime=2016-03-02 01:02:03
+    lastUpdateTime=2016-03-02 01:02:03
+    signatures=PackageSignatures{42c0d8e8 [41a3f2d0, 5b6c7d8e]}
+    requested permissions:
+      android.permission.INTERNET
+    install permissions:
+      android.permission.INTERNET: granted=true
+    User 0: installed=true hidden=false stopped=false notLaunched=false enabled=2
+      runtime permissions:
+`
+
+func TestPackageInfoOldFormat(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+	d.SetShellOutput("dumpsys package 'com.example.old'", dumpsysPackageM)
+
+	info, err := device.PackageInfo(context.Background(), "com.example.old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.VersionCode != 7 || info.TargetSdk != 23 || info.MinSdk != 0 || info.Enabled || info.Installer != "" {
+		t.Fatalf("unexpected package info %+v", info)
+	}
+	if !reflect.DeepEqual(info.Signatures, []string{"41a3f2d0", "5b6c7d8e"}) {
+		t.Fatalf("unexpected signatures %v", info.Signatures)
+	}
+}
@@ -0,0 +1,107 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/youluo1230/adbutils"
+	"github.com/youluo1230/adbutils/adbtest"
+)
+
+func TestPermissions(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+	ctx := context.Background()
+	d.OnShell("dumpsys package 'com.example.app'", func(req adbtest.ShellRequest) adbtest.ShellResponse {
+		return adbtest.ShellResponse{Stdout: dumpsysPackage}
+	})
+	var commands []string
+	d.OnShellPrefix("pm ", func(req adbtest.ShellRequest) adbtest.ShellResponse {
+		commands = append(commands, req.Command)
+		if req.Command == "pm grant com.example.app android.permission.SEND_SMS" {
+			return adbtest.ShellResponse{
+				Stderr:   "Exception occurred while executing 'grant':\njava.lang.SecurityException: Package com.example.app has not requested permission android.permission.SEND_SMS\n",
+				ExitCode: 255,
+			}
+		}
+		return adbtest.ShellResponse{}
+	})
+
+	if err := device.GrantPermission(ctx, "com.example.app", "android.permission.READ_SMS"); err != nil {
+		t.Fatal(err)
+	}
+	err := device.GrantPermission(ctx, "com.example.app", "android.permission.SEND_SMS")
+	var shellErr *adbutils.ShellError
+	if !errors.As(err, &shellErr) || shellErr.Command != "pm grant com.example.app android.permission.SEND_SMS" {
+		t.Fatalf("expect shell error, got %v", err)
+	}
+
+	permissions, err := device.ListPermissions(ctx, "com.example.app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(permissions) != 2 || permissions[0].Name != "android.permission.CAMERA" || !permissions[0].Granted {
+		t.Fatalf("unexpected permissions %+v", permissions)
+	}
+	// 只撤销已授予的 CAMERA
+	commands = nil
+	if err := device.ResetPermissions(ctx, "com.example.app"); err != nil {
+		t.Fatal(err)
+	}
+	if err := device.ResetPermissions(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"pm revoke com.example.app android.permission.CAMERA", "pm reset-permissions"}
+	if !reflect.DeepEqual(commands, expect) {
+		t.Fatalf("unexpected commands %q", commands)
+	}
+}
+
+func TestAppOps(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+	ctx := context.Background()
+	var set string
+	d.OnShellPrefix("appops set ", func(req adbtest.ShellRequest) adbtest.ShellResponse {
+		if req.Command == "appops set com.example.app BAD_OP allow" {
+			return adbtest.ShellResponse{Stderr: "Error: Unknown operation string: BAD_OP\n", ExitCode: 255}
+		}
+		set = req.Command
+		return adbtest.ShellResponse{}
+	})
+	// Android 11 的输出
+	d.SetShellOutput("appops get com.example.app", `Uid mode: LEGACY_STORAGE: allow
+CAMERA: allow; time=+2m10s ago; duration=+5s
+  null=[
+    Access: [top-s] 2023-05-06 07:08:09.123 (-2m10s) duration=+5s
+  ]
+SYSTEM_ALERT_WINDOW: ignore
+`)
+
+	if err := device.SetAppOp(ctx, "com.example.app", "SYSTEM_ALERT_WINDOW", adbutils.AppOpAllow); err != nil {
+		t.Fatal(err)
+	}
+	if set != "appops set com.example.app SYSTEM_ALERT_WINDOW allow" {
+		t.Fatalf("unexpected command %q", set)
+	}
+	if err := device.SetAppOp(ctx, "com.example.app", "BAD_OP", adbutils.AppOpAllow); !errors.As(err, new(*adbutils.ShellError)) {
+		t.Fatalf("expect shell error, got %v", err)
+	}
+
+	ops, err := device.GetAppOps(ctx, "com.example.app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []adbutils.AppOp{
+		{Name: "LEGACY_STORAGE", Mode: adbutils.AppOpAllow, Uid: true},
+		{Name: "CAMERA", Mode: adbutils.AppOpAllow},
+		{Name: "SYSTEM_ALERT_WINDOW", Mode: adbutils.AppOpIgnore},
+	}
+	if !reflect.DeepEqual(ops, expect) {
+		t.Fatalf("unexpected app ops %+v", ops)
+	}
+}
@@ -0,0 +1,97 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/youluo1230/adbutils"
+	"github.com/youluo1230/adbutils/adbtest"
+)
+
+func TestProcesses(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+	ctx := context.Background()
+	d.SetShellOutput("ps -A -o PID,PPID,USER,RSS,VSZ,NAME,ARGS", `  PID  PPID USER            RSS    VSZ NAME                        ARGS
+    1     0 root           9876 10943100 init                        /system/bin/init second_stage
+    2     0 root              0      0 kthreadd                    [kthreadd]
+ 1234   345 u0_a153      123456 15432100 com.example.app             com.example.app
+ 1240   345 u0_a153       65432 14321000 com.example.app:push        com.example.app:push
+`)
+
+	processes, err := device.Processes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(processes) != 4 {
+		t.Fatalf("unexpected processes %+v", processes)
+	}
+	expect := adbutils.Process{PID: 1, PPID: 0, User: "root", RSS: 9876, VSZ: 10943100, Name: "init", Args: "/system/bin/init second_stage"}
+	if processes[0] != expect {
+		t.Fatalf("unexpected process %+v", processes[0])
+	}
+
+	d.SetShellOutput("pidof 'com.example.app'", "1234\n")
+	pids, err := device.Pidof(ctx, "com.example.app")
+	if err != nil || !reflect.DeepEqual(pids, []int{1234}) {
+		t.Fatalf("unexpected pids %v %v", pids, err)
+	}
+
+	var killed []string
+	d.OnShellPrefix("kill ", func(req adbtest.ShellRequest) adbtest.ShellResponse {
+		if req.Command == "kill -s KILL 1" {
+			return adbtest.ShellResponse{Stderr: "/system/bin/sh: kill: 1: Operation not permitted\n", ExitCode: 1}
+		}
+		killed = append(killed, req.Command)
+		return adbtest.ShellResponse{}
+	})
+	if err := device.Kill(ctx, 1240, adbutils.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	if err := device.Kill(ctx, 1, adbutils.SIGKILL); !errors.As(err, new(*adbutils.ShellError)) {
+		t.Fatalf("expect shell error, got %v", err)
+	}
+	if err := device.KillAll(ctx, "com.example.app"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(killed, []string{"kill -s USR1 1240", "kill -s KILL 1234"}) {
+		t.Fatalf("unexpected kill commands %q", killed)
+	}
+	d.SetShellOutput("pidof 'com.example.missing'", "")
+	if err := device.KillAll(ctx, "com.example.missing"); !errors.Is(err, adbutils.ErrProcessNotFound) {
+		t.Fatalf("expect process not found, got %v", err)
+	}
+}
+
+func TestProcessesToolbox(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+	ctx := context.Background()
+	// Android 6.0 的 toolbox ps 不支持 -A 和 -o, 也没有 pidof
+	d.SetShellOutput("ps -A -o PID,PPID,USER,RSS,VSZ,NAME,ARGS", "USER      PID   PPID  VSIZE  RSS   WCHAN              PC  NAME\n")
+	d.SetShellOutput("ps", `USER      PID   PPID  VSIZE  RSS   WCHAN              PC  NAME
+root      1     0     8904   780   SyS_epoll_ 0000000000 S /init
+u0_a53    2345  301   1523456 45678 SyS_epoll_ 0000000000 S com.example.app
+`)
+	d.SetShellOutput("pidof 'com.example.app'", "/system/bin/sh: pidof: not found\n")
+
+	processes, err := device.Processes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []adbutils.Process{
+		{PID: 1, User: "root", VSZ: 8904, RSS: 780, Name: "/init", Args: "/init"},
+		{PID: 2345, PPID: 301, User: "u0_a53", VSZ: 1523456, RSS: 45678, Name: "com.example.app", Args: "com.example.app"},
+	}
+	if !reflect.DeepEqual(processes, expect) {
+		t.Fatalf("unexpected processes %+v", processes)
+	}
+	pids, err := device.Pidof(ctx, "com.example.app")
+	if err != nil || !reflect.DeepEqual(pids, []int{2345}) {
+		t.Fatalf("unexpected pids %v %v", pids, err)
+	}
+}
@@ -0,0 +1,141 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/youluo1230/adbutils"
+	"github.com/youluo1230/adbutils/adbtest"
+)
+
+func TestSyncProgress(t *testing.T) {
+	server := newServer(t)
+	server.AddDevice("emulator-5554")
+	sync := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"}).Sync()
+	var events []adbutils.Progress
+	sync.OnProgress = func(p adbutils.Progress) {
+		events = append(events, p)
+	}
+
+	content := bytes.Repeat([]byte("progress"), 100000)
+	local := filepath.Join(t.TempDir(), "local.bin")
+	if err := os.WriteFile(local, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sync.Push(local, "/sdcard/remote.bin", 0644, false); err != nil {
+		t.Fatal(err)
+	}
+	last := events[len(events)-1]
+	if last.Path != "/sdcard/remote.bin" || last.Done != int64(len(content)) || last.Total != int64(len(content)) {
+		t.Fatalf("unexpected push progress %+v", last)
+	}
+
+	events = nil
+	if _, err := sync.Pull("/sdcard/remote.bin", filepath.Join(t.TempDir(), "pulled.bin")); err != nil {
+		t.Fatal(err)
+	}
+	last = events[len(events)-1]
+	if last.Done != int64(len(content)) || last.Total != int64(len(content)) || last.Speed <= 0 {
+		t.Fatalf("unexpected pull progress %+v", last)
+	}
+
+	// 目录传输按文件回调
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		p := filepath.Join(dir, name)
+		_ = os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events = nil
+	if _, err := sync.PushDir(context.Background(), dir, "/sdcard/dir", adbutils.SyncDirOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	done := map[string]int64{}
+	for _, p := range events {
+		done[p.Path] = p.Done
+	}
+	if done["/sdcard/dir/a.txt"] != 5 || done["/sdcard/dir/sub/b.txt"] != 9 {
+		t.Fatalf("unexpected dir progress %v", done)
+	}
+}
+
+func TestSyncPullResume(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	content := bytes.Repeat([]byte("0123456789"), 50000)
+	d.FS.WriteFile("/sdcard/fixture.bin", content, 0644)
+	sync := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"}).Sync()
+	var last adbutils.Progress
+	sync.OnProgress = func(p adbutils.Progress) {
+		last = p
+	}
+
+	local := filepath.Join(t.TempDir(), "fixture.bin")
+	if err := os.WriteFile(local, content[:123456], 0644); err != nil {
+		t.Fatal(err)
+	}
+	n, err := sync.PullResume("/sdcard/fixture.bin", local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(content)-123456) {
+		t.Fatalf("resumed %d bytes", n)
+	}
+	if data, _ := os.ReadFile(local); !bytes.Equal(data, content) {
+		t.Fatal("resumed content mismatch")
+	}
+	if last.Done != int64(len(content)) || last.Total != int64(len(content)) {
+		t.Fatalf("unexpected progress %+v", last)
+	}
+
+	// 已完整时不再传输
+	if n, err := sync.PullResume("/sdcard/fixture.bin", local); err != nil || n != 0 {
+		t.Fatalf("expect nothing to pull, got %d %v", n, err)
+	}
+
+	// 本地文件比远端大时重新拉取
+	if err := os.WriteFile(local, append(content, "garbage"...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := sync.PullResume("/sdcard/fixture.bin", local); err != nil || n != int64(len(content)) {
+		t.Fatalf("expect full pull, got %d %v", n, err)
+	}
+	if data, _ := os.ReadFile(local); !bytes.Equal(data, content) {
+		t.Fatal("content mismatch after full pull")
+	}
+
+	if _, err := sync.PullResume("/sdcard/missing", local); !os.IsNotExist(err) {
+		t.Fatalf("expect not exist, got %v", err)
+	}
+
+	// 本地已有部分与远端不一致时重新拉取
+	stale := append([]byte("stale"), content[5:1000]...)
+	if err := os.WriteFile(local, stale, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := sync.PullResume("/sdcard/fixture.bin", local); err != nil || n != int64(len(content)) {
+		t.Fatalf("expect full pull, got %d %v", n, err)
+	}
+	if data, _ := os.ReadFile(local); !bytes.Equal(data, content) {
+		t.Fatal("content mismatch after stale resume")
+	}
+
+	// tail 失败时错误信息不能留在本地文件中
+	if err := os.WriteFile(local, content[:1000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	d.OnShellPrefix("tail ", func(adbtest.ShellRequest) adbtest.ShellResponse {
+		return adbtest.ShellResponse{Stderr: "tail: /sdcard/fixture.bin: I/O error\n", ExitCode: 1}
+	})
+	if _, err := sync.PullResume("/sdcard/fixture.bin", local); err == nil {
+		t.Fatal("expect resume error")
+	}
+	if data, _ := os.ReadFile(local); !bytes.Equal(data, content[:1000]) {
+		t.Fatalf("local file not truncated back, size %d", len(data))
+	}
+}
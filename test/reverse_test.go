@@ -0,0 +1,53 @@
+package test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/youluo1230/adbutils"
+)
+
+func TestReverse(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+
+	if err := device.Reverse("tcp:8000", "tcp:9000", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := device.Reverse("localabstract:agent", "tcp:9001", false); err != nil {
+		t.Fatal(err)
+	}
+	var adbErr *adbutils.AdbError
+	if err := device.Reverse("tcp:8000", "tcp:9002", true); !errors.As(err, &adbErr) {
+		t.Fatalf("expect norebind error, got %v", err)
+	}
+	items, err := device.ReverseList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []adbutils.ReverseItem{
+		{Remote: "tcp:8000", Local: "tcp:9000"},
+		{Remote: "localabstract:agent", Local: "tcp:9001"},
+	}
+	if !reflect.DeepEqual(items, expect) {
+		t.Fatalf("unexpected reverse list %v", items)
+	}
+
+	if err := device.ReverseRemove("tcp:8000"); err != nil {
+		t.Fatal(err)
+	}
+	if err := device.ReverseRemove("tcp:8000"); !errors.As(err, &adbErr) {
+		t.Fatalf("expect not found error, got %v", err)
+	}
+	if !reflect.DeepEqual(d.Reverses(), expect[1:]) {
+		t.Fatalf("unexpected reverses %v", d.Reverses())
+	}
+	if err := device.ReverseRemoveAll(); err != nil {
+		t.Fatal(err)
+	}
+	if items, err := device.ReverseList(); err != nil || len(items) != 0 {
+		t.Fatalf("expect empty list, got %v %v", items, err)
+	}
+}
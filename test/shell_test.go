@@ -0,0 +1,134 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/youluo1230/adbutils"
+	"github.com/youluo1230/adbutils/adbtest"
+)
+
+func TestShell2(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	d.OnShell("ls /data", func(adbtest.ShellRequest) adbtest.ShellResponse {
+		return adbtest.ShellResponse{Stdout: "", Stderr: "ls: /data: Permission denied\n", ExitCode: 1}
+	})
+	d.OnShell("cat", func(req adbtest.ShellRequest) adbtest.ShellResponse {
+		// stdin 已被关闭, 读取不会阻塞
+		data, _ := ioutil.ReadAll(req.Stdin)
+		return adbtest.ShellResponse{Stdout: string(data)}
+	})
+	device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+
+	ret, err := device.Shell2("ls /data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.ReturnCode != 1 || ret.Stdout != "" || ret.Stderr != "ls: /data: Permission denied\n" || ret.Output != ret.Stderr {
+		t.Fatalf("unexpected return %+v", ret)
+	}
+	if ret, err = device.Shell2("cat"); err != nil || ret.ReturnCode != 0 {
+		t.Fatalf("unexpected return %+v %v", ret, err)
+	}
+	if ret, err = device.Shell2("missing-cmd"); err != nil || ret.ReturnCode != 127 {
+		t.Fatalf("unexpected return %+v %v", ret, err)
+	}
+}
+
+func TestShell2Fallback(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	d.SetFeatures("cmd")
+	d.OnShell("ls /data", func(adbtest.ShellRequest) adbtest.ShellResponse {
+		return adbtest.ShellResponse{Stderr: "ls: /data: Permission denied\n", ExitCode: 1}
+	})
+	device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+
+	ret, err := device.Shell2("ls /data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.ReturnCode != 1 || ret.Output != "ls: /data: Permission denied\n" {
+		t.Fatalf("unexpected return %+v", ret)
+	}
+	if ret, err = device.Shell2("echo hello"); err != nil || ret.ReturnCode != 0 || ret.Stdout != "hello\n" {
+		t.Fatalf("unexpected return %+v %v", ret, err)
+	}
+	// 以 & 或 ; 结尾、以注释结尾的命令也能取得退出码
+	for _, command := range []string{"false &", "false;", "false # comment"} {
+		expect := 1
+		if command == "false &" {
+			expect = 0
+		}
+		if ret, err = device.Shell2(command); err != nil || ret.ReturnCode != expect {
+			t.Fatalf("%q: unexpected return %+v %v", command, ret, err)
+		}
+	}
+}
+
+func TestOpenShell(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	d.OnShell("", func(req adbtest.ShellRequest) adbtest.ShellResponse {
+		if len(req.Options) != 3 || req.Options[1] != "TERM=xterm-256color" || req.Options[2] != "pty" {
+			return adbtest.ShellResponse{Stderr: "unexpected options", ExitCode: 2}
+		}
+		size := <-req.WindowSize
+		fmt.Fprintf(req.Stdout, "size %s\n", size)
+		scanner := bufio.NewScanner(req.Stdin)
+		for scanner.Scan() {
+			if scanner.Text() == "exit" {
+				return adbtest.ShellResponse{ExitCode: 3}
+			}
+			fmt.Fprintf(req.Stdout, "$ %s\n", scanner.Text())
+			size = <-req.WindowSize
+			fmt.Fprintf(req.Stdout, "size %s\n", size)
+		}
+		return adbtest.ShellResponse{}
+	})
+	device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+	session, err := device.OpenShell(context.Background(), adbutils.ShellOptions{Rows: 24, Cols: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go io.Copy(ioutil.Discard, session.Stderr)
+	reader := bufio.NewReader(session.Stdout)
+	expectLine := func(expect string) {
+		t.Helper()
+		line, err := reader.ReadString('\n')
+		if err != nil || line != expect {
+			t.Fatalf("expect %q, got %q %v", expect, line, err)
+		}
+	}
+	expectLine("size 24x80,0x0\n")
+	if _, err := io.WriteString(session.Stdin, "ls\n"); err != nil {
+		t.Fatal(err)
+	}
+	expectLine("$ ls\n")
+	if err := session.Resize(50, 120); err != nil {
+		t.Fatal(err)
+	}
+	expectLine("size 50x120,0x0\n")
+	if _, err := io.WriteString(session.Stdin, "exit\n"); err != nil {
+		t.Fatal(err)
+	}
+	code, err := session.Wait()
+	if err != nil || code != 3 {
+		t.Fatalf("unexpected exit %d %v", code, err)
+	}
+}
+
+func TestOpenShellNotSupported(t *testing.T) {
+	server := newServer(t)
+	server.AddDevice("emulator-5554").SetFeatures()
+	device := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"})
+	if _, err := device.OpenShell(context.Background(), adbutils.ShellOptions{}); !errors.Is(err, adbutils.ErrNotSupported) {
+		t.Fatalf("expect not supported, got %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/youluo1230/adbutils"
+	"github.com/youluo1230/adbutils/adbtest"
+)
+
+// syncFeatures 分别测试 sync v2 和不支持 v2 的旧设备
+var syncFeatures = map[string][]string{
+	"v2": {"cmd", "shell_v2", "stat_v2", "ls_v2", "sendrecv_v2"},
+	"v1": {"cmd", "shell_v2"},
+}
+
+func TestSyncPushPull(t *testing.T) {
+	for name, features := range syncFeatures {
+		t.Run(name, func(t *testing.T) {
+			testSyncPushPull(t, features)
+		})
+	}
+}
+
+func testSyncPushPull(t *testing.T, features []string) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	d.SetFeatures(features...)
+	sync := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"}).Sync()
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 20000)
+	local := filepath.Join(t.TempDir(), "local.bin")
+	if err := os.WriteFile(local, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	n, err := sync.Push(local, "/sdcard/dir/remote.bin", 0644, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(content)) {
+		t.Fatalf("pushed %d, expect %d", n, len(content))
+	}
+	if data, ok := d.FS.ReadFile("/sdcard/dir/remote.bin"); !ok || !bytes.Equal(data, content) {
+		t.Fatal("remote content mismatch")
+	}
+
+	stat, err := sync.Stat("/sdcard/dir/remote.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size != int64(len(content)) || stat.Mode&0777 != 0644 || stat.Mtime == nil {
+		t.Fatalf("unexpected stat %+v", stat)
+	}
+
+	pulled := filepath.Join(t.TempDir(), "pulled.bin")
+	if n, err = sync.Pull("/sdcard/dir/remote.bin", pulled); err != nil || n != int64(len(content)) {
+		t.Fatalf("pull %d %v", n, err)
+	}
+	if data, _ := os.ReadFile(pulled); !bytes.Equal(data, content) {
+		t.Fatal("pulled content mismatch")
+	}
+
+	if _, err := sync.ReadBytes("/sdcard/missing"); err == nil {
+		t.Fatal("expect error reading missing file")
+	}
+	if exist, err := sync.Exist("/sdcard/missing"); err != nil || exist {
+		t.Fatalf("expect missing file not exist, %v", err)
+	}
+}
+
+func TestSyncList(t *testing.T) {
+	for name, features := range syncFeatures {
+		t.Run(name, func(t *testing.T) {
+			testSyncList(t, features)
+		})
+	}
+}
+
+func testSyncList(t *testing.T, features []string) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	d.SetFeatures(features...)
+	d.FS.WriteFile("/sdcard/a.txt", []byte("a"), 0644)
+	d.FS.WriteFile("/sdcard/b/c.txt", []byte("bc"), 0644)
+	sync := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"}).Sync()
+	list, err := sync.List("/sdcard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range *list {
+		names = append(names, f.Path)
+	}
+	if len(names) != 4 || names[2] != "a.txt" || names[3] != "b" {
+		t.Fatalf("unexpected list %v", names)
+	}
+}
+
+func TestSyncStatV2(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	mtime := time.Unix(1700000000, 0)
+	d.FS.Put("/sdcard/video.mp4", &adbtest.File{Mode: syscall.S_IFREG | 0660, Mtime: mtime, Uid: 1000, Gid: 1015, Size: 5 << 30})
+	d.FS.Put("/sdcard/link", &adbtest.File{Mode: syscall.S_IFLNK | 0777, Data: []byte("/sdcard/video.mp4"), Mtime: mtime})
+	sync := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"}).Sync()
+
+	stat, err := sync.Stat("/sdcard/video.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size != 5<<30 || stat.Uid != 1000 || stat.Gid != 1015 || stat.Nlink != 1 || stat.Ino == 0 ||
+		!stat.Mtime.Equal(mtime) || stat.Atime == nil || stat.Ctime == nil {
+		t.Fatalf("unexpected stat %+v", stat)
+	}
+	lstat, err := sync.Lstat("/sdcard/link")
+	if err != nil || lstat.Mode&syscall.S_IFMT != syscall.S_IFLNK {
+		t.Fatalf("unexpected lstat %+v %v", lstat, err)
+	}
+	list, err := sync.List("/sdcard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range *list {
+		if f.Path == "video.mp4" && f.Size != 5<<30 {
+			t.Fatalf("unexpected list size %d", f.Size)
+		}
+	}
+	if missing, err := sync.Stat("/sdcard/missing"); err != nil || missing.Mtime != nil {
+		t.Fatalf("expect missing file, got %+v %v", missing, err)
+	}
+
+	// 旧设备只有 32 位的 STAT
+	d.SetFeatures("cmd")
+	old := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"}).Sync()
+	if stat, err := old.Stat("/sdcard/video.mp4"); err != nil || stat.Size != (5<<30)&0xffffffff {
+		t.Fatalf("unexpected v1 stat %+v %v", stat, err)
+	}
+}
+
+func TestSyncCompression(t *testing.T) {
+	content := bytes.Repeat([]byte("compress me please "), 50000)
+	local := filepath.Join(t.TempDir(), "local.txt")
+	if err := os.WriteFile(local, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	codecs := map[adbutils.Compression]string{
+		adbutils.CompressionBrotli: adbutils.FeatureSendRecvV2Brotli,
+		adbutils.CompressionLZ4:    adbutils.FeatureSendRecvV2LZ4,
+		adbutils.CompressionZstd:   adbutils.FeatureSendRecvV2Zstd,
+	}
+	for codec, feature := range codecs {
+		t.Run(codec.String(), func(t *testing.T) {
+			server := newServer(t)
+			d := server.AddDevice("emulator-5554")
+			d.SetFeatures("cmd", "stat_v2", "sendrecv_v2", feature)
+			sync := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"}).Sync()
+			sync.Compression = codec
+			if _, err := sync.Push(local, "/sdcard/remote.txt", 0644, true); err != nil {
+				t.Fatal(err)
+			}
+			if data, _ := d.FS.ReadFile("/sdcard/remote.txt"); !bytes.Equal(data, content) {
+				t.Fatal("remote content mismatch")
+			}
+			data, err := sync.ReadBytes("/sdcard/remote.txt")
+			if err != nil || !bytes.Equal(data, content) {
+				t.Fatalf("read content mismatch %v", err)
+			}
+
+			// 设备不支持指定的压缩算法
+			d.SetFeatures("cmd", "stat_v2", "sendrecv_v2")
+			sync = server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"}).Sync()
+			sync.Compression = codec
+			if _, err := sync.ReadBytes("/sdcard/remote.txt"); !errors.Is(err, adbutils.ErrNotSupported) {
+				t.Fatalf("expect not supported, got %v", err)
+			}
+			// 自动选择时回退到不压缩
+			sync.Compression = adbutils.CompressionAny
+			if data, err := sync.ReadBytes("/sdcard/remote.txt"); err != nil || !bytes.Equal(data, content) {
+				t.Fatalf("fallback read mismatch %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/youluo1230/adbutils"
+	"github.com/youluo1230/adbutils/adbtest"
+)
+
+func TestSyncPushDir(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	sync := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"}).Sync()
+
+	local := t.TempDir()
+	mtime := time.Unix(1600000000, 0)
+	write := func(name, content string, perm os.FileMode) {
+		p := filepath.Join(local, name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(content), perm); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(p, mtime, mtime)
+	}
+	write("a.txt", "a", 0600)
+	write("sub/b.log", "b", 0644)
+	write("sub/c.txt", "c", 0644)
+	write("skip/x.txt", "x", 0644)
+	os.Mkdir(filepath.Join(local, "empty"), 0700)
+	if err := os.Symlink("a.txt", filepath.Join(local, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(local, 0750)
+	dirMtime := time.Unix(1500000000, 0)
+	for _, dir := range []string{"empty", "sub", ""} {
+		os.Chtimes(filepath.Join(local, dir), dirMtime, dirMtime)
+	}
+
+	opts := adbutils.SyncDirOptions{Include: []string{"*.txt"}, Exclude: []string{"skip"}, Symlinks: adbutils.SymlinkPreserve}
+	res, err := sync.PushDir(context.Background(), local, "/sdcard/dst", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Files != 3 || res.Skipped != 0 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	a := d.FS.Stat("/sdcard/dst/a.txt")
+	if a == nil || string(a.Data) != "a" || a.Mode != syscall.S_IFREG|0600 || !a.Mtime.Equal(mtime) {
+		t.Fatalf("unexpected a.txt %+v", a)
+	}
+	if c := d.FS.Stat("/sdcard/dst/sub/c.txt"); c == nil || string(c.Data) != "c" {
+		t.Fatalf("unexpected c.txt %+v", c)
+	}
+	for _, missing := range []string{"/sdcard/dst/sub/b.log", "/sdcard/dst/skip"} {
+		if d.FS.Stat(missing) != nil {
+			t.Fatalf("%s should not be pushed", missing)
+		}
+	}
+	if empty := d.FS.Stat("/sdcard/dst/empty"); empty == nil || !empty.IsDir() {
+		t.Fatal("empty dir not created")
+	}
+	// 目录的权限和修改时间在文件推送后设置
+	for dir, perm := range map[string]uint32{"/sdcard/dst": 0750, "/sdcard/dst/sub": 0755, "/sdcard/dst/empty": 0700} {
+		if f := d.FS.Stat(dir); f == nil || f.Mode != syscall.S_IFDIR|perm || !f.Mtime.Equal(dirMtime) {
+			t.Fatalf("unexpected dir %s %+v", dir, f)
+		}
+	}
+	if link := d.FS.Stat("/sdcard/dst/link.txt"); link == nil || link.Mode&syscall.S_IFMT != syscall.S_IFLNK || string(link.Data) != "a.txt" {
+		t.Fatalf("unexpected link %+v", link)
+	}
+
+	// --sync 只推送变化的文件
+	write("sub/c.txt", "changed", 0644)
+	opts.Symlinks = adbutils.SymlinkSkip
+	res, err = sync.PushDir(context.Background(), local, "/sdcard/dst", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Sync = true
+	if res, err = sync.PushDir(context.Background(), local, "/sdcard/dst", opts); err != nil || res.Files != 0 || res.Skipped != 2 {
+		t.Fatalf("unexpected sync result %+v %v", res, err)
+	}
+	write("a.txt", "aa", 0600)
+	if res, err = sync.PushDir(context.Background(), local, "/sdcard/dst", opts); err != nil || res.Files != 1 || res.Skipped != 1 {
+		t.Fatalf("unexpected sync result %+v %v", res, err)
+	}
+}
+
+func TestSyncPullDir(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	mtime := time.Unix(1600000000, 0)
+	d.FS.Put("/sdcard/src/a.txt", &adbtest.File{Mode: syscall.S_IFREG | 0640, Data: []byte("a"), Mtime: mtime})
+	d.FS.Put("/sdcard/src/sub/b.txt", &adbtest.File{Mode: syscall.S_IFREG | 0644, Data: []byte("b"), Mtime: mtime})
+	d.FS.Put("/sdcard/src/sub/c.log", &adbtest.File{Mode: syscall.S_IFREG | 0644, Data: []byte("c"), Mtime: mtime})
+	d.FS.Put("/sdcard/src/link.txt", &adbtest.File{Mode: syscall.S_IFLNK | 0777, Data: []byte("a.txt"), Mtime: mtime})
+	dirMtime := time.Unix(1500000000, 0)
+	d.FS.Put("/sdcard/src", &adbtest.File{Mode: syscall.S_IFDIR | 0750, Mtime: dirMtime})
+	d.FS.Put("/sdcard/src/sub", &adbtest.File{Mode: syscall.S_IFDIR | 0750, Mtime: dirMtime})
+	sync := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"}).Sync()
+
+	local := t.TempDir()
+	opts := adbutils.SyncDirOptions{Exclude: []string{"*.log"}, Symlinks: adbutils.SymlinkPreserve}
+	res, err := sync.PullDir(context.Background(), "/sdcard/src", local, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Files != 3 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	st, err := os.Stat(filepath.Join(local, "a.txt"))
+	if err != nil || st.Mode().Perm() != 0640 || !st.ModTime().Equal(mtime) {
+		t.Fatalf("unexpected a.txt %v %v", st, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(local, "sub", "b.txt")); string(data) != "b" {
+		t.Fatalf("unexpected b.txt %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(local, "sub", "c.log")); !os.IsNotExist(err) {
+		t.Fatal("c.log should be excluded")
+	}
+	if target, err := os.Readlink(filepath.Join(local, "link.txt")); err != nil || target != "a.txt" {
+		t.Fatalf("unexpected link %q %v", target, err)
+	}
+	// 目录的权限和修改时间在文件写入后设置
+	for _, dir := range []string{local, filepath.Join(local, "sub")} {
+		st, err := os.Stat(dir)
+		if err != nil || st.Mode().Perm() != 0750 || !st.ModTime().Equal(dirMtime) {
+			t.Fatalf("unexpected dir %s %v %v", dir, st, err)
+		}
+	}
+
+	opts.Sync = true
+	opts.Symlinks = adbutils.SymlinkSkip
+	if res, err = sync.PullDir(context.Background(), "/sdcard/src", local, opts); err != nil || res.Files != 0 || res.Skipped != 2 {
+		t.Fatalf("unexpected sync result %+v %v", res, err)
+	}
+
+	// 跟随链接时拉取链接指向的内容
+	followed := t.TempDir()
+	opts = adbutils.SyncDirOptions{Include: []string{"link.txt"}, Symlinks: adbutils.SymlinkFollow}
+	if res, err = sync.PullDir(context.Background(), "/sdcard/src", followed, opts); err != nil || res.Files != 1 {
+		t.Fatalf("unexpected follow result %+v %v", res, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(followed, "link.txt")); string(data) != "a" {
+		t.Fatalf("unexpected followed content %q", data)
+	}
+}
@@ -0,0 +1,89 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"syscall"
+	"testing"
+	"testing/fstest"
+
+	"github.com/youluo1230/adbutils"
+)
+
+func TestSyncFS(t *testing.T) {
+	for name, features := range syncFeatures {
+		t.Run(name, func(t *testing.T) {
+			server := newServer(t)
+			d := server.AddDevice("emulator-5554")
+			d.SetFeatures(features...)
+			d.FS.WriteFile("/sdcard/a.txt", []byte("hello"), 0644)
+			d.FS.WriteFile("/sdcard/dir/b.txt", []byte("world"), 0600)
+			d.FS.WriteFile("/sdcard/dir/sub/c.json", []byte(`{"c":1}`), 0644)
+			d.FS.WriteFile("/data/secret", []byte("secret"), 0600)
+			sync := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"}).Sync()
+			fsys := sync.FS(context.Background(), "/sdcard")
+
+			if err := fstest.TestFS(fsys, "a.txt", "dir/b.txt", "dir/sub/c.json"); err != nil {
+				t.Fatal(err)
+			}
+
+			var walked []string
+			err := fs.WalkDir(fsys, ".", func(path string, entry fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				walked = append(walked, path)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(walked) != 6 {
+				t.Fatalf("unexpected walk %v", walked)
+			}
+
+			if data, err := fs.ReadFile(fsys, "dir/b.txt"); err != nil || string(data) != "world" {
+				t.Fatalf("read %q %v", data, err)
+			}
+			if info, err := fs.Stat(fsys, "dir"); err != nil || !info.IsDir() {
+				t.Fatalf("unexpected dir info %v %v", info, err)
+			}
+			info, err := fs.Stat(fsys, "dir/b.txt")
+			if err != nil || info.Mode() != 0600 || info.Size() != 5 || info.Name() != "b.txt" {
+				t.Fatalf("unexpected file info %v %v", info, err)
+			}
+			if _, ok := info.Sys().(*adbutils.FileInfo); !ok {
+				t.Fatalf("unexpected Sys %T", info.Sys())
+			}
+			if _, err := fs.Stat(fsys, "missing"); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("expect not exist, got %v", err)
+			}
+			if _, err := fsys.Open("../data/secret"); !errors.Is(err, fs.ErrInvalid) {
+				t.Fatalf("expect invalid path, got %v", err)
+			}
+			if _, err := fs.ReadDir(fsys, "a.txt"); err == nil {
+				t.Fatal("expect error reading a file as directory")
+			}
+		})
+	}
+}
+
+func TestFileMode(t *testing.T) {
+	cases := []struct {
+		mode   int
+		expect fs.FileMode
+	}{
+		{syscall.S_IFREG | 0644, 0644},
+		{syscall.S_IFDIR | 0755, fs.ModeDir | 0755},
+		{syscall.S_IFLNK | 0777, fs.ModeSymlink | 0777},
+		{syscall.S_IFCHR | 0666, fs.ModeDevice | fs.ModeCharDevice | 0666},
+		{syscall.S_IFDIR | syscall.S_ISVTX | 0777, fs.ModeDir | fs.ModeSticky | 0777},
+		{syscall.S_IFREG | syscall.S_ISUID | 0755, fs.ModeSetuid | 0755},
+	}
+	for _, c := range cases {
+		if mode := (adbutils.FileInfo{Mode: c.mode}).FileMode(); mode != c.expect {
+			t.Errorf("FileMode(%o) = %v, expect %v", c.mode, mode, c.expect)
+		}
+	}
+}
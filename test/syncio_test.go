@@ -0,0 +1,171 @@
+package test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/youluo1230/adbutils"
+)
+
+func TestSyncCreateOpen(t *testing.T) {
+	for name, features := range syncFeatures {
+		t.Run(name, func(t *testing.T) {
+			testSyncCreateOpen(t, features)
+		})
+	}
+}
+
+func testSyncCreateOpen(t *testing.T, features []string) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	d.SetFeatures(features...)
+	sync := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"}).Sync()
+	ctx := context.Background()
+
+	// 大量小块写入应合并为 64K 的 DATA 包
+	mtime := time.Unix(1600000000, 0)
+	w, err := sync.Create(ctx, "/sdcard/gen.txt", 0600, mtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var content bytes.Buffer
+	line := []byte("generated line of content\n")
+	for content.Len() < 200*1024 {
+		content.Write(line)
+		if _, err := w.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n, expect := d.SyncDataPackets(), (content.Len()+65535)/65536; n != expect {
+		t.Fatalf("sent %d DATA packets, expect %d", n, expect)
+	}
+	f := d.FS.Stat("/sdcard/gen.txt")
+	if f == nil || !bytes.Equal(f.Data, content.Bytes()) {
+		t.Fatal("remote content mismatch")
+	}
+	if f.Mode&0777 != 0600 || !f.Mtime.Equal(mtime) {
+		t.Fatalf("unexpected mode %o mtime %v", f.Mode, f.Mtime)
+	}
+
+	// 一次写入超过 64K 也要拆包
+	w, err = sync.Create(ctx, "/sdcard/big.bin", 0644, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	big := bytes.Repeat([]byte{0xab}, 300*1024)
+	if _, err := w.Write(big); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := d.FS.ReadFile("/sdcard/big.bin"); !bytes.Equal(data, big) {
+		t.Fatal("big content mismatch")
+	}
+
+	// 直接读入 gzip
+	r, err := sync.Open(ctx, "/sdcard/gen.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	if _, err := io.Copy(zw, r); err != nil {
+		t.Fatal(err)
+	}
+	_ = zw.Close()
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(zr); !bytes.Equal(data, content.Bytes()) {
+		t.Fatal("gzip content mismatch")
+	}
+
+	// 中途关闭不影响之后的请求
+	r, err = sync.Open(ctx, "/sdcard/big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	_ = r.Close()
+	if _, err := r.Read(make([]byte, 10)); err == nil {
+		t.Fatal("expect error reading closed file")
+	}
+
+	if _, err := sync.Open(ctx, "/sdcard/missing"); err == nil {
+		t.Fatal("expect error opening missing file")
+	}
+}
+
+func TestSyncCreateFail(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	d.FS.MkdirAll("/sdcard/dir", 0755)
+	sync := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"}).Sync()
+
+	w, err := sync.Create(context.Background(), "/sdcard/dir", 0644, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, "data"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err == nil {
+		t.Fatal("expect error writing to a directory")
+	}
+}
+
+func TestSyncSocketTime(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	client := server.Client()
+	client.SocketTime = 100 * time.Millisecond
+	sync := client.Device(adbutils.SerialNTransportID{Serial: "emulator-5554"}).Sync()
+	ctx := context.Background()
+
+	// 传输时间超过 SocketTime 时不应超时
+	w, err := sync.Create(ctx, "/sdcard/slow.txt", 0644, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(bytes.Repeat([]byte("a"), 100*1024)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(250 * time.Millisecond)
+	if _, err := w.Write([]byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if f := d.FS.Stat("/sdcard/slow.txt"); f == nil || len(f.Data) != 100*1024+1 {
+		t.Fatal("remote content mismatch")
+	}
+
+	r, err := sync.Open(ctx, "/sdcard/slow.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := io.ReadFull(r, make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(250 * time.Millisecond)
+	rest, err := io.ReadAll(r)
+	if err != nil || len(rest) != 100*1024+1-10 {
+		t.Fatalf("read %d bytes, %v", len(rest), err)
+	}
+}
@@ -0,0 +1,60 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/youluo1230/adbutils"
+)
+
+func nextEvent(t *testing.T, ch <-chan adbutils.DeviceEvent) adbutils.DeviceEvent {
+	t.Helper()
+	select {
+	case event, ok := <-ch:
+		if !ok {
+			t.Fatal("event channel closed")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for device event")
+	}
+	return adbutils.DeviceEvent{}
+}
+
+func TestTrackDevices(t *testing.T) {
+	server := newServer(t)
+	server.AddDevice("emulator-5554")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := server.Client().TrackDevices(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := func(present bool, serial, status string) {
+		t.Helper()
+		event := nextEvent(t, ch)
+		if event != (adbutils.DeviceEvent{Present: present, Serial: serial, Status: status}) {
+			t.Fatalf("unexpected event %+v", event)
+		}
+	}
+	expect(true, "emulator-5554", "device")
+
+	d := server.AddDevice("a918b5a9")
+	expect(true, "a918b5a9", "device")
+	d.SetState("unauthorized")
+	expect(true, "a918b5a9", "unauthorized")
+	d.SetState("device")
+	expect(true, "a918b5a9", "device")
+	server.RemoveDevice("emulator-5554")
+	expect(false, "emulator-5554", adbutils.StatusAbsent)
+
+	// 模拟 adb server 重启, 重连后补发期间的变化
+	server.CloseConnections()
+	server.RemoveDevice("a918b5a9")
+	expect(false, "a918b5a9", adbutils.StatusAbsent)
+
+	cancel()
+	for range ch {
+	}
+}
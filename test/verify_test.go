@@ -0,0 +1,64 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/youluo1230/adbutils"
+	"github.com/youluo1230/adbutils/adbtest"
+)
+
+func TestPushVerify(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	sync := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"}).Sync()
+	ctx := context.Background()
+	local := filepath.Join(t.TempDir(), "app.apk")
+	if err := os.WriteFile(local, []byte("apk content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, algo := range []adbutils.Checksum{adbutils.ChecksumSHA256, adbutils.ChecksumMD5, adbutils.ChecksumAuto} {
+		if _, err := sync.PushFile(ctx, local, "/data/local/tmp/app.apk", adbutils.PushOptions{Verify: algo}); err != nil {
+			t.Fatalf("%v: %v", algo, err)
+		}
+	}
+
+	// 模拟传输损坏, 重试一次后仍不一致
+	calls := 0
+	d.OnShellPrefix("sha256sum ", func(req adbtest.ShellRequest) adbtest.ShellResponse {
+		calls++
+		return adbtest.ShellResponse{Stdout: strings.Repeat("0", 64) + "  /data/local/tmp/app.apk\n"}
+	})
+	_, err := sync.PushFile(ctx, local, "/data/local/tmp/app.apk", adbutils.PushOptions{Verify: adbutils.ChecksumSHA256, Retries: 1})
+	var mismatch *adbutils.ChecksumError
+	if !errors.As(err, &mismatch) || mismatch.Algorithm != adbutils.ChecksumSHA256 || mismatch.Remote != strings.Repeat("0", 64) {
+		t.Fatalf("expect checksum mismatch, got %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expect 2 attempts, got %d", calls)
+	}
+}
+
+func TestPushVerifyMD5Fallback(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	d.OnShellPrefix("sha256sum ", func(req adbtest.ShellRequest) adbtest.ShellResponse {
+		return adbtest.ShellResponse{Stderr: "/system/bin/sh: sha256sum: not found\n", ExitCode: 127}
+	})
+	sync := server.Client().Device(adbutils.SerialNTransportID{Serial: "emulator-5554"}).Sync()
+	local := filepath.Join(t.TempDir(), "app.apk")
+	if err := os.WriteFile(local, []byte("apk content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sync.Push(local, "/data/local/tmp/app.apk", 0644, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sync.PushFile(context.Background(), local, "/data/local/tmp/app.apk", adbutils.PushOptions{Verify: adbutils.ChecksumSHA256}); err == nil {
+		t.Fatal("expect error without sha256sum")
+	}
+}
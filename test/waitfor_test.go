@@ -0,0 +1,94 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/youluo1230/adbutils"
+	"github.com/youluo1230/adbutils/adbtest"
+)
+
+func TestWaitFor(t *testing.T) {
+	server := newServer(t)
+	adb := server.Client()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		server.AddDevice("emulator-5554").SetState("recovery")
+	}()
+	if err := adb.WaitForTransport(ctx, "emulator-5554", adbutils.TransportLocal, adbutils.StateRecovery); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		server.RemoveDevice("emulator-5554")
+	}()
+	if err := adb.WaitFor(ctx, "emulator-5554", adbutils.StateDisconnect); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := adb.WaitFor(ctx, "emulator-5554", "fastboot"); err == nil {
+		t.Fatal("expect error for unsupported state")
+	}
+	if err := adb.WaitForTransport(ctx, "emulator-5554", "bluetooth", adbutils.StateDevice); err == nil {
+		t.Fatal("expect error for unsupported transport")
+	}
+
+	short, cancelShort := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancelShort()
+	if err := adb.WaitForTransport(short, "emulator-5554", adbutils.TransportUSB, adbutils.StateDevice); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+}
+
+func TestWaitForBootCompleted(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	d.SetProp("sys.boot_completed", "")
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		d.SetProp("sys.boot_completed", "1")
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Client().WaitForBootCompleted(ctx, "emulator-5554"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitForBootCompletedAnyDevice(t *testing.T) {
+	server := newServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		d := server.AddDevice("emulator-5554")
+		d.SetProp("sys.boot_completed", "")
+		time.Sleep(300 * time.Millisecond)
+		d.SetProp("sys.boot_completed", "1")
+	}()
+	if err := server.Client().WaitForBootCompleted(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+
+}
+
+func TestWaitForBootCompletedUnauthorized(t *testing.T) {
+	server := newServer(t)
+	d := server.AddDevice("emulator-5554")
+	// 第一次轮询后设备变为未授权, 不会自动恢复, 应返回错误而不是等到超时
+	d.OnShell("getprop sys.boot_completed", func(req adbtest.ShellRequest) adbtest.ShellResponse {
+		d.SetState("unauthorized")
+		return adbtest.ShellResponse{Stdout: "\n"}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Client().WaitForBootCompleted(ctx, "emulator-5554"); !errors.Is(err, adbutils.ErrUnauthorized) {
+		t.Fatalf("expect unauthorized, got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package adbutils
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	trackRetryMinInterval = 100 * time.Millisecond
+	trackRetryMaxInterval = 2 * time.Second
+	// StatusAbsent 设备被拔出时 DeviceEvent 的状态
+	StatusAbsent = "absent"
+)
+
+// TrackDevices
+//
+//	@Description: 通过 host:track-devices-l 长连接监听设备插拔和状态变化,
+//	adb server 重启后自动重连并补发期间的变化; ctx 结束时关闭返回的管道
+//	@receiver adb
+//	@param ctx
+//	@return <-chan DeviceEvent
+//	@return error
+func (adb *AdbClient) TrackDevices(ctx context.Context) (<-chan DeviceEvent, error) {
+	c, err := adb.connectContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = adb.startTrack(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	ch := make(chan DeviceEvent)
+	go adb.trackDevices(ctx, c, ch)
+	return ch, nil
+}
+
+func (adb *AdbClient) startTrack(c *AdbConnection) error {
+	if err := c.SendCommand("host:track-devices-l"); err != nil {
+		return err
+	}
+	return c.CheckOkay()
+}
+
+func (adb *AdbClient) trackDevices(ctx context.Context, c *AdbConnection, ch chan<- DeviceEvent) {
+	defer close(ch)
+	states := map[string]string{}
+	for {
+		_ = adb.readTrack(ctx, c, states, ch)
+		c.Close()
+		if ctx.Err() != nil {
+			return
+		}
+		if c = adb.reconnectTrack(ctx); c == nil {
+			return
+		}
+	}
+}
+
+// readTrack
+//
+//	@Description: 持续读取设备列表并与上一次的状态对比, 直到连接断开
+//	@receiver adb
+//	@param ctx
+//	@param c
+//	@param states serial 到状态的映射, 重连后继续使用
+//	@param ch
+//	@return error
+func (adb *AdbClient) readTrack(ctx context.Context, c *AdbConnection, states map[string]string, ch chan<- DeviceEvent) error {
+	for {
+		content, err := c.ReadStringBlock()
+		if err != nil {
+			return err
+		}
+		current := parseDeviceStates(content)
+		for _, event := range diffDeviceStates(states, current) {
+			select {
+			case ch <- event:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		for serial := range states {
+			delete(states, serial)
+		}
+		for serial, state := range current {
+			states[serial] = state
+		}
+	}
+}
+
+func (adb *AdbClient) reconnectTrack(ctx context.Context) *AdbConnection {
+	interval := trackRetryMinInterval
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		}
+		c, err := adb.dialContext(ctx)
+		if err == nil {
+			if err = adb.startTrack(c); err == nil {
+				return c
+			}
+			c.Close()
+		}
+		if interval *= 2; interval > trackRetryMaxInterval {
+			interval = trackRetryMaxInterval
+		}
+		timer.Reset(interval)
+	}
+}
+
+// parseDeviceStates 解析 host:devices(-l) 的输出, 每行第一列为 serial 第二列为状态
+func parseDeviceStates(content string) map[string]string {
+	states := map[string]string{}
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		states[fields[0]] = fields[1]
+	}
+	return states
+}
+
+// diffDeviceStates 对比两次设备列表, 生成按 serial 排序的新增、状态变化和移除事件
+func diffDeviceStates(previous, current map[string]string) []DeviceEvent {
+	var events []DeviceEvent
+	for serial, state := range current {
+		if previous[serial] != state {
+			events = append(events, DeviceEvent{Present: true, Serial: serial, Status: state})
+		}
+	}
+	for serial := range previous {
+		if _, ok := current[serial]; !ok {
+			events = append(events, DeviceEvent{Present: false, Serial: serial, Status: StatusAbsent})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Serial < events[j].Serial
+	})
+	return events
+}
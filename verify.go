@@ -0,0 +1,182 @@
+package adbutils
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Checksum
+// @Description: 推送后校验文件内容的算法, 远端使用设备上的 sha256sum/md5sum 计算
+type Checksum int
+
+const (
+	ChecksumNone Checksum = iota // 不校验
+	ChecksumAuto                 // 优先 sha256sum, 设备上没有时使用 md5sum
+	ChecksumSHA256
+	ChecksumMD5
+)
+
+func (c Checksum) String() string {
+	switch c {
+	case ChecksumNone:
+		return "none"
+	case ChecksumAuto:
+		return "auto"
+	case ChecksumSHA256:
+		return "sha256"
+	case ChecksumMD5:
+		return "md5"
+	}
+	return fmt.Sprintf("Checksum(%d)", int(c))
+}
+
+// command 设备上计算该算法的命令
+func (c Checksum) command() string {
+	if c == ChecksumMD5 {
+		return "md5sum"
+	}
+	return "sha256sum"
+}
+
+// ChecksumError
+// @Description: 推送后设备上文件的校验值与本地不一致
+type ChecksumError struct {
+	Path      string // 设备上的路径
+	Algorithm Checksum
+	Local     string
+	Remote    string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("adb: %s checksum mismatch for %s, local %s, remote %s", e.Algorithm, e.Path, e.Local, e.Remote)
+}
+
+// PushOptions
+// @Description: PushFile 的选项
+type PushOptions struct {
+	Mode    int       // 文件权限, 零值为 0644
+	Mtime   time.Time // 文件修改时间, 零值为当前时间
+	Verify  Checksum  // 推送后校验的算法, 本地的校验值在传输时同时计算
+	Retries int       // 校验不一致时重新推送的次数
+}
+
+// PushFile
+//
+//	@Description: 推送本地文件到设备, 可以在推送后通过设备上的 sha256sum/md5sum 校验内容,
+//	不一致时返回 *ChecksumError, 设置了 Retries 时重新推送
+//	@receiver sync
+//	@param ctx
+//	@param src
+//	@param dst
+//	@param opts
+//	@return int64 最后一次推送的字节数
+//	@return error
+func (sync Sync) PushFile(ctx context.Context, src, dst string, opts PushOptions) (int64, error) {
+	if opts.Mode == 0 {
+		opts.Mode = 0644
+	}
+	for attempt := 0; ; attempt++ {
+		n, err := sync.pushFileOnce(ctx, src, dst, opts)
+		var mismatch *ChecksumError
+		if errors.As(err, &mismatch) && attempt < opts.Retries {
+			continue
+		}
+		return n, err
+	}
+}
+
+func (sync Sync) pushFileOnce(ctx context.Context, src, dst string, opts PushOptions) (int64, error) {
+	file, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	size := readerSize(file)
+	hashes := map[Checksum]hash.Hash{}
+	switch opts.Verify {
+	case ChecksumNone:
+	case ChecksumAuto:
+		hashes[ChecksumSHA256], hashes[ChecksumMD5] = sha256.New(), md5.New()
+	case ChecksumSHA256:
+		hashes[ChecksumSHA256] = sha256.New()
+	case ChecksumMD5:
+		hashes[ChecksumMD5] = md5.New()
+	default:
+		return 0, fmt.Errorf("unknown checksum %v", opts.Verify)
+	}
+	var r io.Reader = file
+	if len(hashes) > 0 {
+		writers := make([]io.Writer, 0, len(hashes))
+		for _, h := range hashes {
+			writers = append(writers, h)
+		}
+		r = sizedReader{io.TeeReader(file, io.MultiWriter(writers...)), size}
+	}
+	n, err := sync.send(ctx, r, dst, syscall.S_IFREG|opts.Mode, opts.Mtime)
+	if err != nil || len(hashes) == 0 {
+		return n, err
+	}
+	algo, remote, err := sync.remoteChecksum(ctx, dst, opts.Verify)
+	if err != nil {
+		return n, err
+	}
+	if local := hex.EncodeToString(hashes[algo].Sum(nil)); local != remote {
+		return n, &ChecksumError{Path: dst, Algorithm: algo, Local: local, Remote: remote}
+	}
+	return n, nil
+}
+
+// remoteChecksum
+//
+//	@Description: 在设备上计算文件的校验值, ChecksumAuto 时 sha256sum 不可用则使用 md5sum
+//	@receiver sync
+//	@param ctx
+//	@param path
+//	@param algo
+//	@return Checksum 实际使用的算法
+//	@return string 小写十六进制
+//	@return error
+func (sync Sync) remoteChecksum(ctx context.Context, path string, algo Checksum) (Checksum, string, error) {
+	return sync.shellChecksum(ctx, func(tool string) string {
+		return tool + " " + shellQuote(path)
+	}, algo)
+}
+
+// shellChecksum 执行 command 生成的命令, tool 为 sha256sum 或 md5sum, 输出的第一列为校验值
+func (sync Sync) shellChecksum(ctx context.Context, command func(tool string) string, algo Checksum) (Checksum, string, error) {
+	candidates := []Checksum{algo}
+	if algo == ChecksumAuto {
+		candidates = []Checksum{ChecksumSHA256, ChecksumMD5}
+	}
+	var err error
+	for _, c := range candidates {
+		cmd := c.command()
+		var output string
+		output, err = sync.AdbClient.ShellContext(ctx, sync.Serial, command(cmd))
+		if err != nil {
+			return c, "", err
+		}
+		fields := strings.Fields(output)
+		size := sha256.Size
+		if c == ChecksumMD5 {
+			size = md5.Size
+		}
+		if len(fields) > 0 && len(fields[0]) == 2*size {
+			if _, decodeErr := hex.DecodeString(fields[0]); decodeErr == nil {
+				return c, strings.ToLower(fields[0]), nil
+			}
+		}
+		err = &AdbError{Service: "shell:" + cmd, Message: strings.TrimSpace(output)}
+	}
+	return algo, "", err
+}